@@ -0,0 +1,115 @@
+package client
+
+import (
+	"errors"
+	"hash/crc32"
+	"sort"
+	"strconv"
+
+	"github.com/mediocregopher/hyrax/types"
+)
+
+// The number of points each endpoint gets on a Ring by default. More points
+// means a more even spread of keys across endpoints
+const DefaultRingPoints = 100
+
+// Ring is a consistent hash ring of hyrax endpoints. Every key maps to exactly
+// one endpoint on the ring, and adding or removing an endpoint only changes the
+// mapping of the keys which were on (or end up on) that endpoint
+type Ring struct {
+	points []uint32
+	les    map[uint32]*types.ListenEndpoint
+}
+
+// NewRing returns a Ring containing the given endpoints, each of which will get
+// the given number of points on the ring (DefaultRingPoints is a good choice)
+func NewRing(les []*types.ListenEndpoint, points int) *Ring {
+	r := Ring{
+		points: make([]uint32, 0, len(les)*points),
+		les:    make(map[uint32]*types.ListenEndpoint, len(les)*points),
+	}
+	for _, le := range les {
+		leStr := le.String()
+		for i := 0; i < points; i++ {
+			p := crc32.ChecksumIEEE([]byte(leStr + "-" + strconv.Itoa(i)))
+			if _, ok := r.les[p]; ok {
+				continue
+			}
+			r.points = append(r.points, p)
+			r.les[p] = le
+		}
+	}
+	sort.Sort(uint32Slice(r.points))
+	return &r
+}
+
+// Get returns the endpoint the given key maps to, or nil if the ring is empty
+func (r *Ring) Get(key string) *types.ListenEndpoint {
+	if len(r.points) == 0 {
+		return nil
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.les[r.points[i]]
+}
+
+type uint32Slice []uint32
+
+func (s uint32Slice) Len() int           { return len(s) }
+func (s uint32Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint32Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// RingClient is a Client which is connected to several hyrax nodes, and sends
+// each command to the node its key maps to on a Ring. This way all writes to
+// and monitors of a particular key happen on the same node
+type RingClient struct {
+	ring    *Ring
+	clients map[string]Client
+}
+
+// NewRingClient connects to all of the given endpoints and returns a
+// RingClient for them. All push messages from all the endpoints will be pushed
+// onto the given channel, which can be nil if you want to ignore them. If any
+// connection fails all previous ones will be closed and the error returned
+func NewRingClient(
+	les []*types.ListenEndpoint, pushCh chan *types.Action) (*RingClient, error) {
+
+	if len(les) == 0 {
+		return nil, errors.New("no endpoints given")
+	}
+
+	rc := RingClient{
+		ring:    NewRing(les, DefaultRingPoints),
+		clients: make(map[string]Client, len(les)),
+	}
+	for _, le := range les {
+		cl, err := NewClient(le, pushCh)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		rc.clients[le.String()] = cl
+	}
+	return &rc, nil
+}
+
+// Endpoint returns the endpoint the given key will be sent to
+func (rc *RingClient) Endpoint(key string) *types.ListenEndpoint {
+	return rc.ring.Get(key)
+}
+
+// Cmd sends the command to the node its StorageKey maps to. See the Client
+// interface
+func (rc *RingClient) Cmd(a *types.Action) (interface{}, error) {
+	return rc.clients[rc.ring.Get(a.StorageKey).String()].Cmd(a)
+}
+
+// Close closes the connections to all of the nodes
+func (rc *RingClient) Close() {
+	for _, cl := range rc.clients {
+		cl.Close()
+	}
+}
@@ -1,15 +1,55 @@
 package config
 
 import (
+	"fmt"
 	"github.com/grooveshark/golib/gslog"
 	"github.com/mediocregopher/flagconfig"
+	"strings"
+	"time"
 
 	"github.com/mediocregopher/hyrax/types"
 )
 
+// The type of storage backend to use
+var StorageType string
+
 // Information for connecting to the storage instance
 var StorageInfo string
 
+// The addresses of the storage instances to shard data across, taken from the
+// comma separated StorageInfo
+var StorageShards []string
+
+// The path to a file changing which datastore commands are allowed. Empty to
+// use the defaults
+var StorageCommands string
+
+// The addresses of the replicas of the storage instances, which commands that
+// only read are sent to. Each is a comma separated list with one address per
+// shard, in the same order as StorageShards
+var StorageReplicas []string
+
+// The addresses of the redis sentinels to find the datastore through. If set,
+// StorageShards are the master names of the primaries rather than addresses
+var StorageSentinels []string
+
+// The most blocking commands (like blpop) which can be waiting on the
+// datastore at once, and the longest they can be made to wait for
+var StorageBlockingMax int
+var StorageBlockingTimeout time.Duration
+
+// Whether replies from the datastore which are numbers, but which the datastore
+// returns as strings (like zscore), are returned to clients as numbers
+var StorageTypedReplies bool
+
+// The lua scripts clients can call by name, each of the form
+// "<name>::<file>::<number of keys>", optionally followed by "::modifies"
+var Scripts []string
+
+// Whether to republish change notifications from the storage backend, and if
+// so whether to do so as local or global key change events. Empty if disabled
+var StorageNotify string
+
 // Flags for whether or not to use global/key-specific authentication
 var UseGlobalAuth, UseKeyAuth bool
 
@@ -25,12 +65,35 @@ var PushToEndpoints []*types.ListenEndpoint
 // The list of endpoints this node will pull global key change events from
 var PullFromEndpoints []*types.ListenEndpoint
 
+// The topologies nodes can be arranged in
+const (
+	TopologyTree = "tree"
+	TopologyMesh = "mesh"
+)
+
+// How this node is connected to the rest of the cluster, one of the Topology
+// constants
+var Topology string
+
+// Whether this node takes part in gossip at all, and the list of endpoints it
+// will gossip with to find the rest of the cluster. Giving any seeds turns
+// gossip on, but a node which is only ever gossiped with (like the first seed)
+// has none
+var Gossip bool
+var GossipSeeds []*types.ListenEndpoint
+
 // Secret key to use when generating commands which interact with other nodes
 var InteractionSecret string
 
 // The endpoint to advertise to other nodes that they should connect to
 var MyEndpoint *types.ListenEndpoint
 
+// The shortest and longest times to wait between attempts to reconnect to
+// another node or the datastore, and the number of attempts to make to
+// reconnect to another node before giving up on it (0 to never give up)
+var ReconnectMin, ReconnectMax time.Duration
+var ReconnectMaxAttempts int
+
 // The minumum level (debug, info, warn, error, fatal) of logs to send and the
 // file to send them to (or "stdout"/"stderr")
 var LogLevel, LogFile string
@@ -45,11 +108,53 @@ func init() {
 
 func Load() error {
 	fc := flagconfig.New("hyrax")
+	fc.StrParam(
+		"storage-type",
+		"The type of datastore to use. Can be \"redis\", \"memory\" (keeps everything in this node's memory, useful for testing and development) or \"bolt\" (an embedded database file, whose path is given by storage-info)",
+		"redis",
+	)
 	fc.StrParam(
 		"storage-info",
-		"Info needed for connecting to the datastore(s). For redis this is just the address redis is listening on",
+		"Info needed for connecting to the datastore(s). For redis this is the address redis is listening on, or a comma separated list of addresses to shard data across",
 		"127.0.0.1:6379",
 	)
+	fc.StrParam(
+		"storage-commands",
+		"The path to a file which changes which datastore commands clients are allowed to call, and which are admin-only. See the redis doc page for the format. Only supported by the redis backend",
+		"",
+	)
+	fc.StrParams(
+		"storage-replica",
+		"The address of a replica of the datastore, which commands that only read will be sent to. When sharding this is a comma separated list with a replica for each shard, in the same order as storage-info. Can be specified multiple times",
+	)
+	fc.StrParams(
+		"storage-sentinel",
+		"The address of a redis sentinel to find the redis primary through. If set, the storage-info addresses are instead the master names the sentinels know the primaries by. Can be specified multiple times",
+	)
+	fc.IntParam(
+		"storage-blocking-max",
+		"The most blocking commands (like blpop) which can be waiting on the datastore at once. Each one has its own connection to the datastore",
+		100,
+	)
+	fc.StrParam(
+		"storage-blocking-timeout",
+		"The longest blocking commands (like blpop) can be made to wait for",
+		"60s",
+	)
+	fc.FlagParam(
+		"storage-typed-replies",
+		"Whether replies which are numbers, but which the datastore returns as strings (like zscore and incrbyfloat), are returned as numbers",
+		false,
+	)
+	fc.StrParams(
+		"script",
+		"A lua script clients can call by name, of the form \"<name>::<file>::<number of keys>\", with \"::modifies\" on the end if the script modifies its keys. Only supported by the redis backend. Can be specified multiple times",
+	)
+	fc.StrParam(
+		"storage-notify",
+		"If set, subscribe to change notifications from the datastore and republish them as key change events. Can be \"local\" (publish them as if they happened on this node, set this on only one node per datastore) or \"global\" (publish them only to this node's clients, set this on every node)",
+		"",
+	)
 	fc.StrParams(
 		"listen-endpoint",
 		"The type, address, and format to listen for client connections on, separated by a \"::\". At the moment the only type is tcp, the only format is json. Can be specified multiple times",
@@ -64,6 +169,20 @@ func Load() error {
 		"pull-from-endpoint",
 		"The endpoint address (see listen-endpoint for format) this node will pull global keychange events from. Can be specified multiple times",
 	)
+	fc.StrParam(
+		"topology",
+		"How this node is arranged with the other nodes in the cluster. Can be \"tree\" (local keychange events are sent to the push-to-endpoints, global ones are pulled from the pull-from-endpoints) or \"mesh\" (local keychange events are sent directly to every other node, and to this one)",
+		TopologyTree,
+	)
+	fc.FlagParam(
+		"gossip",
+		"Whether to take part in gossip, answering other nodes which gossip with this one. Implied by gossip-seed, and only needs to be given to nodes which have no seeds of their own",
+		false,
+	)
+	fc.StrParams(
+		"gossip-seed",
+		"The endpoint address (see listen-endpoint for format) of a node to gossip with to discover the rest of the cluster. Every node discovered will be pushed local keychange events. Can be specified multiple times",
+	)
 	fc.StrParam(
 		"interaction-secret",
 		"The secret key to use when interacting with other nodes. Must be found in the global keys list on all nodes this node might talk to",
@@ -74,6 +193,21 @@ func Load() error {
 		"The endpoint address (see listen-endpoint for format) this node will advertise to other nodes that they should connect to",
 		"tcp::json::localhost:2379",
 	)
+	fc.StrParam(
+		"reconnect-min",
+		"The time to wait before the first attempt at reconnecting to another node or the datastore. Each attempt after waits twice as long, up to reconnect-max, with a random jitter",
+		"500ms",
+	)
+	fc.StrParam(
+		"reconnect-max",
+		"The longest time to wait between attempts at reconnecting to another node or the datastore",
+		"30s",
+	)
+	fc.IntParam(
+		"reconnect-max-attempts",
+		"The number of attempts to make at reconnecting to another node before giving up on it, 0 to never give up. Connections to the datastore never give up",
+		0,
+	)
 	fc.StrParam(
 		"log-level",
 		"The minimum level of logs to send (debug, info, warn, error, fatal)",
@@ -109,9 +243,30 @@ func Load() error {
 	}
 
 	Secrets = is
+	StorageType = strings.ToLower(fc.GetStr("storage-type"))
 	StorageInfo = fc.GetStr("storage-info")
+	StorageShards = StorageShards[:0]
+	for _, addr := range strings.Split(StorageInfo, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			StorageShards = append(StorageShards, addr)
+		}
+	}
+	StorageCommands = fc.GetStr("storage-commands")
+	StorageReplicas = fc.GetStrs("storage-replica")
+	StorageSentinels = fc.GetStrs("storage-sentinel")
+	StorageNotify = fc.GetStr("storage-notify")
+	StorageBlockingMax = fc.GetInt("storage-blocking-max")
+	StorageTypedReplies = fc.GetFlag("storage-typed-replies")
+	Scripts = fc.GetStrs("script")
 
 	var err error
+	StorageBlockingTimeout, err = time.ParseDuration(
+		fc.GetStr("storage-blocking-timeout"),
+	)
+	if err != nil {
+		return err
+	}
+
 	if ListenEndpoints, err = endpts(fc, "listen-endpoint"); err != nil {
 		return err
 	}
@@ -122,6 +277,17 @@ func Load() error {
 		return err
 	}
 
+	switch Topology = strings.ToLower(fc.GetStr("topology")); Topology {
+	case TopologyTree, TopologyMesh:
+	default:
+		return fmt.Errorf("unknown topology: %s", Topology)
+	}
+
+	if GossipSeeds, err = endpts(fc, "gossip-seed"); err != nil {
+		return err
+	}
+	Gossip = fc.GetFlag("gossip") || len(GossipSeeds) > 0
+
 	InteractionSecret = fc.GetStr("interaction-secret")
 
 	myEndpointRaw := fc.GetStr("my-endpoint")
@@ -133,6 +299,17 @@ func Load() error {
 	UseGlobalAuth = fc.GetFlag("use-global-auth")
 	UseKeyAuth = fc.GetFlag("use-key-auth")
 
+	if ReconnectMin, err = time.ParseDuration(fc.GetStr("reconnect-min")); err != nil {
+		return err
+	}
+	if ReconnectMax, err = time.ParseDuration(fc.GetStr("reconnect-max")); err != nil {
+		return err
+	}
+	if ReconnectMax < ReconnectMin {
+		return fmt.Errorf("reconnect-max is less than reconnect-min")
+	}
+	ReconnectMaxAttempts = fc.GetInt("reconnect-max-attempts")
+
 	LogLevel = fc.GetStr("log-level")
 	LogFile = fc.GetStr("log-file")
 
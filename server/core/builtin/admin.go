@@ -2,9 +2,13 @@ package builtin
 
 import (
 	"errors"
+	"time"
 
 	"github.com/mediocregopher/hyrax/server/auth"
+	"github.com/mediocregopher/hyrax/server/config"
 	"github.com/mediocregopher/hyrax/server/core/dist"
+	"github.com/mediocregopher/hyrax/server/core/keychanges"
+	sdist "github.com/mediocregopher/hyrax/server/dist"
 	stypes "github.com/mediocregopher/hyrax/server/types"
 	"github.com/mediocregopher/hyrax/types"
 )
@@ -45,6 +49,12 @@ func AIgnoreMe(_ stypes.Client, cmd *types.Action) (interface{}, error) {
 	return OK, dist.PullFromLocalManager.CloseClient(listenEndpoint)
 }
 
+// If another node calls AGOSSIP it is telling us what it knows about the
+// cluster, and wants to know what we know in return
+func AGossip(_ stypes.Client, cmd *types.Action) (interface{}, error) {
+	return dist.Gossip(cmd.Args)
+}
+
 func argsToByteSliceSlice(cmd *types.Action) ([][]byte, error) {
 	if len(cmd.Args) < 1 {
 		return nil, wrongNumArgs
@@ -114,3 +124,63 @@ func ASecrets(_ stypes.Client, cmd *types.Action) (interface{}, error) {
 	}
 	return secrets, nil
 }
+
+// AMonCounts returns the number of clients on this node monitoring each key,
+// as well as the number monitoring global and local key changes
+func AMonCounts(_ stypes.Client, cmd *types.Action) (interface{}, error) {
+	return map[string]interface{}{
+		"keys":   keychanges.MonCounts(),
+		"global": keychanges.GlobalCount(),
+		"local":  keychanges.LocalCount(),
+	}, nil
+}
+
+func distStats(m *sdist.Manager) []map[string]interface{} {
+	stats := m.Stats()
+	ret := make([]map[string]interface{}, len(stats))
+	for i, s := range stats {
+		var lastCmd int64
+		if !s.LastCmd.IsZero() {
+			lastCmd = s.LastCmd.Unix()
+		}
+		ret[i] = map[string]interface{}{
+			"endpoint":   s.Endpoint.String(),
+			"state":      s.State,
+			"lastcmd":    lastCmd,
+			"reconnects": s.Reconnects,
+			"events":     s.Events,
+		}
+	}
+	return ret
+}
+
+// ADist returns what this node's connections to other nodes in the cluster
+// look like: the nodes it's pulling global and local key change events from,
+// and the nodes it's pushing its local key change events to
+func ADist(_ stypes.Client, cmd *types.Action) (interface{}, error) {
+	return map[string]interface{}{
+		"node":           stypes.NodeId,
+		"endpoint":       config.MyEndpoint.String(),
+		"topology":       config.Topology,
+		"time":           time.Now().Unix(),
+		"pullfromglobal": distStats(dist.PullFromGlobalManager),
+		"pullfromlocal":  distStats(dist.PullFromLocalManager),
+		"pushto":         distStats(dist.PushToManager),
+	}, nil
+}
+
+// AShard returns which shard of the datastore the key is assigned to
+func AShard(_ stypes.Client, cmd *types.Action) (interface{}, error) {
+	shards := storageUnit.Shards()
+	i := storageUnit.ShardFor(cmd.StorageKey)
+	replicas := []string{}
+	for _, su := range storageUnit.Replicas(i) {
+		replicas = append(replicas, su.Addr)
+	}
+	return map[string]interface{}{
+		"shard":    i,
+		"addr":     shards[i].Addr,
+		"replicas": replicas,
+		"shards":   len(shards),
+	}, nil
+}
@@ -17,26 +17,45 @@ type builtInCommandInfo struct {
 	Func     BuiltInFunc
 	Admin    bool
 	Modifies bool
+
+	// SelfPublishes is set on modifying commands which publish their own key
+	// change events, rather than having the command itself published
+	SelfPublishes bool
 }
 
 var builtInMap = map[string]*builtInCommandInfo{
-	"mglobal": {Func: MGlobal, Admin: true},
-	"mlocal":  {Func: MLocal, Admin: true},
-	"madd":    {Func: MAdd},
-	"mrem":    {Func: MRem},
+	"mglobal":    {Func: MGlobal, Admin: true},
+	"mglobalrem": {Func: MGlobalRem},
+	"mlocal":     {Func: MLocal, Admin: true},
+	"mlocalrem":  {Func: MLocalRem},
+	"madd":       {Func: MAdd},
+	"mrem":       {Func: MRem},
+	"msubs":      {Func: MSubs},
+	"mpub":       {Func: MPub, Modifies: true},
+
+	"eadd":          {Func: EAdd, Modifies: true, SelfPublishes: true},
+	"erem":          {Func: ERem, Modifies: true, SelfPublishes: true},
+	"erefresh":      {Func: ERefresh},
+	"emembers":      {Func: EMembers},
+	"emembersmulti": {Func: EMembersMulti},
+	"ecard":         {Func: ECard},
+	"esend":         {Func: ESend, Modifies: true, SelfPublishes: true},
 
-	"eadd":     {Func: EAdd, Modifies: true},
-	"erem":     {Func: ERem, Modifies: true},
-	"emembers": {Func: EMembers},
-	"ecard":    {Func: ECard},
+	"lacquire": {Func: LAcquire, Modifies: true, SelfPublishes: true},
+	"lrelease": {Func: LRelease, Modifies: true, SelfPublishes: true},
+	"lholder":  {Func: LHolder},
 
 	"alistentome":    {Func: AListenToMe, Admin: true},
 	"aignoreme":      {Func: AIgnoreMe, Admin: true},
+	"agossip":        {Func: AGossip, Admin: true},
 	"aglobalsecrets": {Func: AGlobalSecrets, Admin: true},
 	"asecretsset":    {Func: ASecretsSet, Admin: true},
 	"asecretsadd":    {Func: ASecretsAdd, Admin: true},
 	"asecretsrem":    {Func: ASecretsRem, Admin: true},
 	"asecrets":       {Func: ASecrets, Admin: true},
+	"amoncounts":     {Func: AMonCounts, Admin: true},
+	"adist":          {Func: ADist, Admin: true},
+	"ashard":         {Func: AShard, Admin: true},
 }
 
 func getBuiltInCommandInfo(cmd string) (*builtInCommandInfo, bool) {
@@ -60,6 +79,15 @@ func BuiltInCommandModifies(cmd string) bool {
 	return false
 }
 
+// BuiltInSelfPublishes returns whether or not a given builtin command publishes
+// its own key change events, or false if it's not a valid builtin command
+func BuiltInSelfPublishes(cmd string) bool {
+	if cinfo, ok := getBuiltInCommandInfo(cmd); ok {
+		return cinfo.SelfPublishes
+	}
+	return false
+}
+
 // BuiltInIsAdmin returns whether or not a given builtin command is an admin
 // only command, or false if it's not a valid builtin command
 func BuiltInIsAdmin(cmd string) bool {
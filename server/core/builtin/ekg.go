@@ -1,103 +1,335 @@
 package builtin
 
 import (
+	"errors"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/mediocregopher/hyrax/server/core/keychanges"
 	stypes "github.com/mediocregopher/hyrax/server/types"
 	"github.com/mediocregopher/hyrax/types"
 )
 
-// A mapping of ekgs to ClientIds and their names
-var ekgKeyToClientIdsNames = map[string]map[uint64]string{}
+// The commands of the key change events which are published when a client
+// joins an ekg, changes its id or metadata on an ekg it's already on, and
+// removes itself from an ekg, respectively
+var (
+	AddCmd    = "eadd"
+	UpdateCmd = "eupdate"
+	RemCmd    = "erem"
+)
+
+// ClosedCmd is the command of the key change event which is published when a
+// client is removed from an ekg because it went away, rather than because it
+// called erem
+var ClosedCmd = "eclose"
+
+// The maximum number of fields a client can set in its metadata on an ekg
+const maxEkgMetaSize = 16
+
+var unknownEkgOpt = errors.New("unknown eadd option")
+var badEkgMeta = errors.New("meta must be an object of at most 16 string fields")
+
+// Optional parameters which can be passed into eadd
+type ekgOpts struct {
+	ttl  time.Duration
+	meta map[string]string
+}
+
+// argsToEkgOpts parses the optional arguments to eadd. They take the form of an
+// option name followed by its value, e.g. ["ttl",30,"meta",{"status":"away"}]
+func argsToEkgOpts(cmd *types.Action) (*ekgOpts, error) {
+	opts := ekgOpts{}
+	for i := 0; i < len(cmd.Args); i += 2 {
+		opt, ok := cmd.Args[i].(string)
+		if !ok {
+			return nil, wrongArgType
+		} else if i+1 >= len(cmd.Args) {
+			return nil, wrongNumArgs
+		}
+
+		switch strings.ToLower(opt) {
+		case "ttl":
+			secs, err := argToSeconds(cmd.Args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			opts.ttl = secs
+		case "meta":
+			meta, err := argToEkgMeta(cmd.Args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			opts.meta = meta
+		default:
+			return nil, unknownEkgOpt
+		}
+	}
+	return &opts, nil
+}
+
+// argToEkgMeta takes in an argument which is an object of string fields and
+// returns it as a map
+func argToEkgMeta(arg interface{}) (map[string]string, error) {
+	argM, ok := arg.(map[string]interface{})
+	if !ok || len(argM) > maxEkgMetaSize {
+		return nil, badEkgMeta
+	}
+	meta := make(map[string]string, len(argM))
+	for k, v := range argM {
+		if meta[k], ok = v.(string); !ok {
+			return nil, badEkgMeta
+		}
+	}
+	return meta, nil
+}
+
+// ekgMember describes a single client's membership on an ekg
+type ekgMember struct {
+	name   string
+	meta   map[string]string
+	joined time.Time
+
+	// The id of the node the client is connected to, and the client's
+	// ClientId on that node as a string
+	node, cid string
+
+	// The client itself, only set if it's connected to this node
+	client stypes.Client
+}
+
+func newLocalEkgMember(name string, cid stypes.ClientId) *ekgMember {
+	return &ekgMember{
+		name:   name,
+		joined: time.Now(),
+		node:   stypes.NodeId,
+		cid:    string(cid.Bytes()),
+	}
+}
+
+// metaOrEmpty returns the member's metadata, or an empty map if it has none
+func (m *ekgMember) metaOrEmpty() map[string]string {
+	if m.meta == nil {
+		return map[string]string{}
+	}
+	return m.meta
+}
+
+// info returns everything known about the member, as returned by emembers
+func (m *ekgMember) info() map[string]interface{} {
+	return map[string]interface{}{
+		"id":     m.name,
+		"meta":   m.metaOrEmpty(),
+		"node":   m.node,
+		"cid":    m.cid,
+		"joined": m.joined.Unix(),
+	}
+}
+
+// ekgAction returns the key change event with the given command for the given
+// membership. eadd and eupdate events have the member's metadata and the unix
+// time it joined the ekg as their arguments.
+func ekgAction(cmd, key string, m *ekgMember) *types.Action {
+	a := &types.Action{
+		Command:    cmd,
+		StorageKey: key,
+		Id:         m.name,
+		ClientId:   m.cid,
+	}
+	if cmd == AddCmd || cmd == UpdateCmd {
+		a.Args = []interface{}{m.metaOrEmpty(), m.joined.Unix()}
+	}
+	return a
+}
+
+// ClosedAction returns the key change event which is published when the client
+// with the given ClientId, using the given name, is removed from the ekg
+// because it went away
+func ClosedAction(key, name string, cid stypes.ClientId) *types.Action {
+	return ekgAction(ClosedCmd, key, newLocalEkgMember(name, cid))
+}
+
+// A mapping of ekgs to ClientIds and their memberships
+var ekgKeyToMembers = map[string]map[uint64]*ekgMember{}
 
-// A mapping of ClientIds to the ekgs the client is on and the names it's using
-// for each ekg
-var ekgClientIdToKeysNames = map[uint64]map[string]string{}
+// A mapping of ClientIds to the ekgs the client is on and its membership on
+// each. The memberships are the same as in ekgKeyToMembers
+var ekgClientIdToMembers = map[uint64]map[string]*ekgMember{}
 
 // Lock which coordinates access to the mappings
 var ekgLock sync.RWMutex
 
 // EAdd adds the client to an ekg's set of things it's watching, and adds the
-// ekg's information to the client's set of ekgs its hooked up to
+// ekg's information to the client's set of ekgs its hooked up to. If a ttl is
+// given in the args the client will be removed from the ekg if it doesn't
+// call eadd or erefresh again within that many seconds. Publishes an eadd if
+// the client wasn't already on the ekg, or an eupdate if it was
 func EAdd(c stypes.Client, cmd *types.Action) (interface{}, error) {
 	key := cmd.StorageKey
 	cidi := c.ClientId().Uint64()
-	name := cmd.Id
+	opts, err := argsToEkgOpts(cmd)
+	if err != nil {
+		return nil, err
+	}
+	m := newLocalEkgMember(cmd.Id, c.ClientId())
+	m.meta = opts.meta
+	m.client = c
+
 	ekgLock.Lock()
-	defer ekgLock.Unlock()
+	if opts.ttl > 0 {
+		setEkgTTL(key, cidi, opts.ttl)
+	} else {
+		clearEkgTTL(key, cidi)
+	}
 
-	if clientIdsM, ok := ekgKeyToClientIdsNames[key]; ok {
-		clientIdsM[cidi] = name
+	pubCmd := AddCmd
+	if clientIdsM, ok := ekgKeyToMembers[key]; ok {
+		if oldM, ok := clientIdsM[cidi]; ok {
+			pubCmd = UpdateCmd
+			m.joined = oldM.joined
+		}
+		clientIdsM[cidi] = m
 	} else {
-		ekgKeyToClientIdsNames[key] = map[uint64]string{cidi: name}
+		ekgKeyToMembers[key] = map[uint64]*ekgMember{cidi: m}
 	}
-	if keysM, ok := ekgClientIdToKeysNames[cidi]; ok {
-		keysM[key] = name
+	if keysM, ok := ekgClientIdToMembers[cidi]; ok {
+		keysM[key] = m
 	} else {
-		ekgClientIdToKeysNames[cidi] = map[string]string{key: name}
+		ekgClientIdToMembers[cidi] = map[string]*ekgMember{key: m}
 	}
-	return OK, nil
+	ekgLock.Unlock()
+
+	return OK, keychanges.PubLocal(ekgAction(pubCmd, key, m))
 }
 
 // ERem removes the client from an ekg's set of things it's watching, and
-// removes the ekg's information from the client's set of ekgs its hooked up to
+// removes the ekg's information from the client's set of ekgs its hooked up to.
+// Publishes an erem if the client was on the ekg
 func ERem(c stypes.Client, cmd *types.Action) (interface{}, error) {
 	key := cmd.StorageKey
 	cidi := c.ClientId().Uint64()
 	ekgLock.Lock()
-	defer ekgLock.Unlock()
+	m := removeEkgMember(key, cidi)
+	ekgLock.Unlock()
 
-	// If the client isn't on the ekg, don't bother
-	clientIdsM, ok := ekgKeyToClientIdsNames[key]
-	if !ok {
+	if m == nil {
 		return OK, nil
 	}
+	return OK, keychanges.PubLocal(ekgAction(RemCmd, key, m))
+}
 
-	if len(clientIdsM) == 1 {
-		delete(ekgKeyToClientIdsNames, key)
-	} else {
-		delete(clientIdsM, cidi)
+// removeEkgMember removes the client from the ekg in both mappings, if it's on
+// it at all, and returns the membership which was removed (or nil). ekgLock
+// must be held when calling this
+func removeEkgMember(key string, cidi uint64) *ekgMember {
+	clearEkgTTL(key, cidi)
+
+	clientIdsM, ok := ekgKeyToMembers[key]
+	if !ok {
+		return nil
+	}
+	m, ok := clientIdsM[cidi]
+	if !ok {
+		return nil
 	}
 
-	keysM := ekgClientIdToKeysNames[cidi]
-	if len(keysM) == 1 {
-		delete(ekgClientIdToKeysNames, cidi)
-	} else {
-		delete(keysM, key)
+	delete(clientIdsM, cidi)
+	if len(clientIdsM) == 0 {
+		delete(ekgKeyToMembers, key)
 	}
 
-	return OK, nil
+	if keysM, ok := ekgClientIdToMembers[cidi]; ok {
+		delete(keysM, key)
+		if len(keysM) == 0 {
+			delete(ekgClientIdToMembers, cidi)
+		}
+	}
+	return m
 }
 
-// EMembers returns the list of ids being monitored by an ekg
+// EMembers returns the list of ids being monitored by an ekg, across the whole
+// cluster. If "meta" is given as an argument each member is instead returned
+// as an object containing its id and metadata. If "info" is given each member
+// is returned as an object containing everything known about it.
 func EMembers(c stypes.Client, cmd *types.Action) (interface{}, error) {
 	key := cmd.StorageKey
+	var format string
+	if len(cmd.Args) > 1 {
+		return nil, wrongNumArgs
+	} else if len(cmd.Args) == 1 {
+		s, _ := cmd.Args[0].(string)
+		if format = strings.ToLower(s); format != "meta" && format != "info" {
+			return nil, wrongArgType
+		}
+	}
+
 	ekgLock.RLock()
 	defer ekgLock.RUnlock()
 
-	clientIdsM, ok := ekgKeyToClientIdsNames[key]
-	if !ok {
-		return []string{}, nil
+	members := ekgMembers(key)
+	ret := make([]interface{}, len(members))
+	for i, m := range members {
+		switch format {
+		case "meta":
+			ret[i] = map[string]interface{}{"id": m.name, "meta": m.metaOrEmpty()}
+		case "info":
+			ret[i] = m.info()
+		default:
+			ret[i] = m.name
+		}
 	}
+	return ret, nil
+}
 
-	names := make([]string, 0, len(clientIdsM))
-	for _, name := range clientIdsM {
-		names = append(names, name)
+// EMembersMulti takes in a list of ekgs as its arguments, and returns an object
+// mapping each one to the list of ids being monitored by it, across the whole
+// cluster
+func EMembersMulti(c stypes.Client, cmd *types.Action) (interface{}, error) {
+	if len(cmd.Args) < 1 {
+		return nil, wrongNumArgs
+	}
+	keys := make([]string, len(cmd.Args))
+	for i := range cmd.Args {
+		var ok bool
+		if keys[i], ok = cmd.Args[i].(string); !ok {
+			return nil, wrongArgType
+		}
 	}
-	return names, nil
+
+	ekgLock.RLock()
+	defer ekgLock.RUnlock()
+
+	ret := make(map[string][]string, len(keys))
+	for _, key := range keys {
+		members := ekgMembers(key)
+		names := make([]string, len(members))
+		for i, m := range members {
+			names[i] = m.name
+		}
+		ret[key] = names
+	}
+	return ret, nil
+}
+
+// ekgMembers returns the memberships of all clients in the cluster on the given
+// ekg. ekgLock must be held when calling this
+func ekgMembers(key string) []*ekgMember {
+	members := remoteEkgMembers(key)
+	for _, m := range ekgKeyToMembers[key] {
+		members = append(members, m)
+	}
+	return members
 }
 
-// ECard returns the number of client/id combinations being monitored
+// ECard returns the number of client/id combinations being monitored, across
+// the whole cluster
 func ECard(c stypes.Client, cmd *types.Action) (interface{}, error) {
 	key := cmd.StorageKey
 	ekgLock.RLock()
 	defer ekgLock.RUnlock()
 
-	clientIdsM, ok := ekgKeyToClientIdsNames[key]
-	if !ok {
-		return 0, nil
-	}
-	return len(clientIdsM), nil
+	return len(ekgKeyToMembers[key]) + len(remoteEkgMembers(key)), nil
 }
 
 // EkgsForClient returns a list of all the ekgs a particular client is hooked up
@@ -107,7 +339,7 @@ func EkgsForClient(c stypes.Client) ([]string, []string, error) {
 	ekgLock.RLock()
 	defer ekgLock.RUnlock()
 
-	keysM, ok := ekgClientIdToKeysNames[cidi]
+	keysM, ok := ekgClientIdToMembers[cidi]
 	if !ok {
 		empty := []string{}
 		return empty, empty, nil
@@ -115,9 +347,9 @@ func EkgsForClient(c stypes.Client) ([]string, []string, error) {
 
 	ekgs := make([]string, 0, len(keysM))
 	ids := make([]string, 0, len(keysM))
-	for key, id := range keysM {
+	for key, m := range keysM {
 		ekgs = append(ekgs, key)
-		ids = append(ids, id)
+		ids = append(ids, m.name)
 	}
 	return ekgs, ids, nil
 }
@@ -142,9 +374,15 @@ func CleanClientEkgsShort(ekgs []string, c stypes.Client) error {
 	defer ekgLock.Unlock()
 
 	for _, keyb := range ekgs {
-		delete(ekgKeyToClientIdsNames[keyb], cidi)
+		clearEkgTTL(keyb, cidi)
+		if clientIdsM, ok := ekgKeyToMembers[keyb]; ok {
+			delete(clientIdsM, cidi)
+			if len(clientIdsM) == 0 {
+				delete(ekgKeyToMembers, keyb)
+			}
+		}
 	}
-	delete(ekgClientIdToKeysNames, cidi)
+	delete(ekgClientIdToMembers, cidi)
 
 	return nil
 }
@@ -0,0 +1,262 @@
+package builtin
+
+import (
+	"github.com/grooveshark/golib/gslog"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/core/keychanges"
+	"github.com/mediocregopher/hyrax/server/pubsub"
+	stypes "github.com/mediocregopher/hyrax/server/types"
+	"github.com/mediocregopher/hyrax/types"
+)
+
+// Every node publishes a snapshot of its ekg membership this often. Other nodes
+// use it both to fix up anything they might have missed and to know the node
+// is still alive
+const ekgSyncPeriod = 10 * time.Second
+
+// If no snapshot is seen from a node for this long it's assumed to be dead, and
+// all of its clients are removed from their ekgs
+const ekgNodeTimeout = 3 * ekgSyncPeriod
+
+// The command of ekg snapshots, and the reserved key they're published under.
+// They're internal and no client can madd the key, so clients never see them
+var syncCmd = "esync"
+var syncKey = reservedKeyPrefix + syncCmd
+
+// The ekg membership of a single other node in the cluster
+type ekgNode struct {
+	lastSeen time.Time
+
+	// A mapping of ekgs to ClientIds (as strings) on the node and their
+	// memberships
+	keys map[string]map[string]*ekgMember
+
+	// Events from a node can arrive out of order. These are the EventId of the
+	// latest snapshot applied, and of the latest event applied for each member
+	// since then, so that events older than them can be dropped
+	syncEid    uint64
+	memberEids map[ekgMemberKey]uint64
+}
+
+// Identifies a single member of an ekg on another node
+type ekgMemberKey struct {
+	key, cid string
+}
+
+// stale returns whether an event with the given EventId for the member is older
+// than what's already been applied for it. Events without an EventId are never
+// stale
+func (n *ekgNode) stale(mk ekgMemberKey, eid uint64) bool {
+	return eid != 0 && (eid <= n.syncEid || eid <= n.memberEids[mk])
+}
+
+// A mapping of other nodes' ids to their ekg membership. Uses ekgLock
+var ekgNodes = map[string]*ekgNode{}
+
+func init() {
+	pubsub.AddInternalCommand(syncCmd)
+	cmds := []string{AddCmd, UpdateCmd, RemCmd, ClosedCmd, syncCmd}
+	f := pubsub.NewFilter(cmds, nil)
+	if err := watchGlobal(f, handleRemoteEkgEvent); err != nil {
+		gslog.Fatal(err.Error())
+	}
+	go ekgSyncSpin()
+}
+
+func ekgSyncSpin() {
+	for _ = range time.Tick(ekgSyncPeriod) {
+		if err := keychanges.PubLocal(ekgSnapshot()); err != nil {
+			gslog.Errorf("publishing ekg snapshot: %s", err)
+		}
+		reapEkgNodes()
+	}
+}
+
+// ekgSnapshot returns an action describing all ekg membership on this node. Its
+// args are a flat list of ekg, ClientId, name, metadata, join time quintuplets
+func ekgSnapshot() *types.Action {
+	ekgLock.RLock()
+	defer ekgLock.RUnlock()
+
+	args := []interface{}{}
+	for key, clientIdsM := range ekgKeyToMembers {
+		for _, m := range clientIdsM {
+			args = append(args,
+				key, m.cid, m.name, m.metaOrEmpty(), m.joined.Unix())
+		}
+	}
+	return &types.Action{Command: syncCmd, StorageKey: syncKey, Args: args}
+}
+
+// argToRemoteTime returns the time from a unix timestamp in an ekg event from
+// another node, or the current time if there isn't a valid one
+func argToRemoteTime(arg interface{}) time.Time {
+	switch argt := arg.(type) {
+	case int64:
+		return time.Unix(argt, 0)
+	case float64:
+		return time.Unix(int64(argt), 0)
+	}
+	return time.Now()
+}
+
+// argToRemoteEkgMeta returns the metadata from an ekg event from another node.
+// Having gone over the wire it won't be a map[string]string anymore
+func argToRemoteEkgMeta(arg interface{}) map[string]string {
+	switch argt := arg.(type) {
+	case map[string]string:
+		return argt
+	case map[string]interface{}:
+		meta := make(map[string]string, len(argt))
+		for k, v := range argt {
+			meta[k], _ = v.(string)
+		}
+		return meta
+	}
+	return nil
+}
+
+func handleRemoteEkgEvent(a *types.Action) {
+	// Events from this node are already reflected in the local mappings
+	if a.Origin == "" || a.Origin == stypes.NodeId {
+		return
+	}
+
+	ekgLock.Lock()
+	defer ekgLock.Unlock()
+
+	n, ok := ekgNodes[a.Origin]
+	if !ok {
+		n = &ekgNode{
+			keys:       map[string]map[string]*ekgMember{},
+			memberEids: map[ekgMemberKey]uint64{},
+		}
+		ekgNodes[a.Origin] = n
+	}
+	n.lastSeen = time.Now()
+
+	if a.Command == syncCmd {
+		applyEkgSnapshot(n, a)
+		return
+	}
+	mk := ekgMemberKey{a.StorageKey, a.ClientId}
+	if n.stale(mk, a.EventId) {
+		return
+	}
+	n.memberEids[mk] = a.EventId
+
+	switch a.Command {
+	case AddCmd, UpdateCmd:
+		m := &ekgMember{name: a.Id, node: a.Origin, cid: a.ClientId}
+		if len(a.Args) > 1 {
+			m.meta = argToRemoteEkgMeta(a.Args[0])
+			m.joined = argToRemoteTime(a.Args[1])
+		} else {
+			m.joined = time.Now()
+		}
+		if clientIdsM, ok := n.keys[a.StorageKey]; ok {
+			clientIdsM[a.ClientId] = m
+		} else {
+			n.keys[a.StorageKey] = map[string]*ekgMember{a.ClientId: m}
+		}
+	case RemCmd, ClosedCmd:
+		if clientIdsM, ok := n.keys[a.StorageKey]; ok {
+			delete(clientIdsM, a.ClientId)
+			if len(clientIdsM) == 0 {
+				delete(n.keys, a.StorageKey)
+			}
+		}
+	}
+}
+
+// applyEkgSnapshot replaces the node's ekg membership with that in the
+// snapshot. Members which have had events applied which are newer than the
+// snapshot keep their current membership. ekgLock must be held when calling
+// this
+func applyEkgSnapshot(n *ekgNode, a *types.Action) {
+	if a.EventId != 0 && a.EventId <= n.syncEid {
+		return
+	}
+
+	keys := map[string]map[string]*ekgMember{}
+	setMember := func(mk ekgMemberKey, m *ekgMember) {
+		if clientIdsM, ok := keys[mk.key]; ok {
+			clientIdsM[mk.cid] = m
+		} else {
+			keys[mk.key] = map[string]*ekgMember{mk.cid: m}
+		}
+	}
+	for i := 0; i+4 < len(a.Args); i += 5 {
+		key, _ := a.Args[i].(string)
+		cid, _ := a.Args[i+1].(string)
+		m := &ekgMember{
+			meta:   argToRemoteEkgMeta(a.Args[i+3]),
+			joined: argToRemoteTime(a.Args[i+4]),
+			node:   a.Origin,
+			cid:    cid,
+		}
+		m.name, _ = a.Args[i+2].(string)
+		setMember(ekgMemberKey{key, cid}, m)
+	}
+
+	for mk, eid := range n.memberEids {
+		if a.EventId != 0 && eid <= a.EventId {
+			delete(n.memberEids, mk)
+			continue
+		}
+		if clientIdsM, ok := keys[mk.key]; ok {
+			delete(clientIdsM, mk.cid)
+			if len(clientIdsM) == 0 {
+				delete(keys, mk.key)
+			}
+		}
+		if m, ok := n.keys[mk.key][mk.cid]; ok {
+			setMember(mk, m)
+		}
+	}
+	n.keys = keys
+	n.syncEid = a.EventId
+}
+
+// reapEkgNodes removes the ekg membership of any nodes which haven't been heard
+// from in a while, and lets clients monitoring the affected ekgs know that the
+// members are gone
+func reapEkgNodes() {
+	var closed []*types.Action
+	ekgLock.Lock()
+	for nodeId, n := range ekgNodes {
+		if time.Since(n.lastSeen) < ekgNodeTimeout {
+			continue
+		}
+		gslog.Warnf("No ekg snapshot from node %s, removing its members", nodeId)
+		for key, clientIdsM := range n.keys {
+			for _, m := range clientIdsM {
+				a := ekgAction(ClosedCmd, key, m)
+				a.Origin = nodeId
+				closed = append(closed, a)
+			}
+		}
+		delete(ekgNodes, nodeId)
+	}
+	ekgLock.Unlock()
+
+	// Every node does this for itself, so these only go to this node's clients
+	for _, a := range closed {
+		if err := keychanges.PubMon(a); err != nil {
+			gslog.Errorf("publishing %v: %s", a, err)
+		}
+	}
+}
+
+// remoteEkgMembers returns the memberships of all clients on other nodes which
+// are on the given ekg. ekgLock must be held when calling this
+func remoteEkgMembers(key string) []*ekgMember {
+	members := []*ekgMember{}
+	for _, n := range ekgNodes {
+		for _, m := range n.keys[key] {
+			members = append(members, m)
+		}
+	}
+	return members
+}
@@ -0,0 +1,109 @@
+package builtin
+
+import (
+	"errors"
+	"github.com/grooveshark/golib/gslog"
+	"strconv"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/core/keychanges"
+	stypes "github.com/mediocregopher/hyrax/server/types"
+	"github.com/mediocregopher/hyrax/types"
+)
+
+var noEkgTTL = errors.New("client is not on ekg with a ttl")
+
+// argToSeconds takes in an argument which is a number of seconds, either as a
+// number or a string, and returns it as a duration
+func argToSeconds(arg interface{}) (time.Duration, error) {
+	var secs float64
+	switch argt := arg.(type) {
+	case float64:
+		secs = argt
+	case string:
+		var err error
+		if secs, err = strconv.ParseFloat(argt, 64); err != nil {
+			return 0, wrongArgType
+		}
+	default:
+		return 0, wrongArgType
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// A pending expiry of a client from an ekg
+type ekgExpiry struct {
+	ttl   time.Duration
+	timer *time.Timer
+}
+
+// A mapping of ClientIds to the ekgs they are on with a ttl, and the pending
+// expiry for each. Uses ekgLock
+var ekgExpiries = map[uint64]map[string]*ekgExpiry{}
+
+// setEkgTTL sets (or resets) the ttl on the client's membership in the ekg.
+// ekgLock must be held when calling this
+func setEkgTTL(key string, cidi uint64, ttl time.Duration) {
+	clearEkgTTL(key, cidi)
+
+	exp := &ekgExpiry{ttl: ttl}
+	exp.timer = time.AfterFunc(ttl, func() { expireEkgMember(key, cidi, exp) })
+	if expsM, ok := ekgExpiries[cidi]; ok {
+		expsM[key] = exp
+	} else {
+		ekgExpiries[cidi] = map[string]*ekgExpiry{key: exp}
+	}
+}
+
+// clearEkgTTL removes the ttl on the client's membership in the ekg, if there is
+// one. ekgLock must be held when calling this
+func clearEkgTTL(key string, cidi uint64) {
+	expsM, ok := ekgExpiries[cidi]
+	if !ok {
+		return
+	}
+	if exp, ok := expsM[key]; ok {
+		exp.timer.Stop()
+		delete(expsM, key)
+	}
+	if len(expsM) == 0 {
+		delete(ekgExpiries, cidi)
+	}
+}
+
+func expireEkgMember(key string, cidi uint64, exp *ekgExpiry) {
+	ekgLock.Lock()
+	// The ttl may have been reset or cleared between the timer firing and us
+	// getting the lock, in which case this expiry is no longer valid
+	if ekgExpiries[cidi][key] != exp {
+		ekgLock.Unlock()
+		return
+	}
+	m := removeEkgMember(key, cidi)
+	ekgLock.Unlock()
+	if m == nil {
+		return
+	}
+
+	cid, _ := stypes.ClientIdFromUint64(cidi)
+	if err := keychanges.PubLocal(ClosedAction(key, m.name, cid)); err != nil {
+		gslog.Errorf("publishing ekg expiry of %s on %s: %s", m.name, key, err)
+	}
+}
+
+// ERefresh resets the ttl on the client's membership in an ekg, as given in the
+// eadd which added it. Returns an error if the client is not on the ekg or did
+// not give a ttl when adding itself.
+func ERefresh(c stypes.Client, cmd *types.Action) (interface{}, error) {
+	key := cmd.StorageKey
+	cidi := c.ClientId().Uint64()
+	ekgLock.Lock()
+	defer ekgLock.Unlock()
+
+	exp, ok := ekgExpiries[cidi][key]
+	if !ok {
+		return nil, noEkgTTL
+	}
+	setEkgTTL(key, cidi, exp.ttl)
+	return OK, nil
+}
@@ -0,0 +1,73 @@
+package builtin
+
+import (
+	"github.com/grooveshark/golib/gslog"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/core/keychanges"
+	"github.com/mediocregopher/hyrax/server/pubsub"
+	stypes "github.com/mediocregopher/hyrax/server/types"
+	"github.com/mediocregopher/hyrax/types"
+)
+
+// internalClient is a client which isn't connected to anything. It's used so
+// that parts of hyrax can subscribe to key change events the same way normal
+// clients do
+type internalClient struct {
+	id      stypes.ClientId
+	pushCh  chan *types.Action
+	closeCh chan struct{}
+}
+
+func (ic *internalClient) ClientId() stypes.ClientId {
+	return ic.id
+}
+
+func (ic *internalClient) PushCh() chan<- *types.Action {
+	return ic.pushCh
+}
+
+func (ic *internalClient) ClosingCh() <-chan struct{} {
+	return ic.closeCh
+}
+
+func (ic *internalClient) GoneCh() <-chan struct{} {
+	return ic.closeCh
+}
+
+// watchGlobal subscribes a new internalClient to the global key change events
+// which match the given filter, internal events included, and calls fn on each
+// of them in a separate go-routine
+func watchGlobal(f *pubsub.Filter, fn func(*types.Action)) error {
+	if f == nil {
+		f = &pubsub.Filter{}
+	}
+	f.Internal = true
+
+	ic := &internalClient{
+		id:      stypes.NewClientId(),
+		pushCh:  make(chan *types.Action),
+		closeCh: make(chan struct{}),
+	}
+	if err := keychanges.SubscribeGlobal(ic, f); err != nil {
+		return err
+	}
+
+	go func() {
+		for a := range ic.pushCh {
+			fn(a)
+		}
+	}()
+	return nil
+}
+
+// pushTo pushes the given action directly to the client, giving up if the
+// client closes or doesn't read it in time
+func pushTo(c stypes.Client, a *types.Action) {
+	select {
+	case c.PushCh() <- a:
+	case <-c.ClosingCh():
+	case <-time.After(10 * time.Second):
+		gslog.Warnf("Timeout pushing %s %s to %p", a.Command, a.StorageKey, c)
+	}
+}
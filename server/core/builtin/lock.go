@@ -0,0 +1,416 @@
+package builtin
+
+import (
+	"errors"
+	"github.com/grooveshark/golib/gslog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/core/keychanges"
+	"github.com/mediocregopher/hyrax/server/pubsub"
+	"github.com/mediocregopher/hyrax/server/storage"
+	stypes "github.com/mediocregopher/hyrax/server/types"
+	"github.com/mediocregopher/hyrax/types"
+)
+
+// Builtins which keep state in the datastore keep it under keys starting with
+// this prefix, which clients aren't allowed to act on directly
+const reservedKeyPrefix = "hyrax:"
+
+// ReservedKeyErr is returned when a client tries to act on a reserved key
+var ReservedKeyErr = errors.New("key is reserved for hyrax's own use")
+
+// KeyIsReserved returns whether the given datastore key is one builtins keep
+// their state under, and so must not be touched by clients
+func KeyIsReserved(key string) bool {
+	return strings.HasPrefix(key, reservedKeyPrefix)
+}
+
+// Locks are stored in the datastore so that only one client across the whole
+// cluster can hold a lock at a time. Each lock is set with a lease which the
+// node whose client holds it keeps refreshing, so that if the node dies the
+// lock is eventually freed.
+const (
+	lockKeyPrefix    = reservedKeyPrefix + "lock:"
+	lockLease        = 30 * time.Second
+	lockRefresh      = lockLease / 3
+	lockRetryPeriod  = 5 * time.Second
+	lockReleaseEval  = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+	lockRefreshEval  = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+	lockTokenSepChar = ":"
+)
+
+// The commands of the key change events which are published when a lock is
+// acquired and released. A client waiting on a lock is also pushed an lacquire
+// when it's acquired the lock on its behalf, and a client which loses a lock
+// it was holding without releasing it (e.g. if the datastore lost it) is
+// pushed an llost
+var (
+	LockAcquireCmd = "lacquire"
+	LockReleaseCmd = "lrelease"
+	LockLostCmd    = "llost"
+)
+
+var unknownLockOpt = errors.New("unknown lacquire option")
+var notLockHolder = errors.New("client does not hold lock")
+
+// The storage unit builtins which keep state in the datastore (like locks) use.
+// Set by SetStorageUnit
+var storageUnit *storage.ShardedStorageUnit
+
+// SetStorageUnit sets the storage unit that builtins which need to keep
+// state in the datastore (like locks) will use
+func SetStorageUnit(su *storage.ShardedStorageUnit) {
+	storageUnit = su
+}
+
+// A lock being held by a client on this node
+type heldLock struct {
+	client stypes.Client
+	id     string
+}
+
+// token returns the value stored in the datastore for the lock, which
+// identifies its holder
+func (hl *heldLock) token() string {
+	parts := []string{
+		stypes.NodeId,
+		string(hl.client.ClientId().Bytes()),
+		hl.id,
+	}
+	return strings.Join(parts, lockTokenSepChar)
+}
+
+// A mapping of locks held by clients on this node to their holders
+var heldLocks = map[string]*heldLock{}
+
+// A mapping of locks to the clients on this node waiting on them, in the order
+// they started waiting. A client is only ever in line for a lock once
+var lockWaiters = map[string][]*heldLock{}
+
+// Lock which coordinates access to the mappings
+var lockLock sync.Mutex
+
+// lockScriptFunc returns a go implementation of the lock scripts, for backends
+// which can't run them: if the lock is held by the given token the given
+// command is run on it, otherwise 0 is returned
+func lockScriptFunc(cmd string) storage.ScriptFunc {
+	return func(
+		call func(string, ...interface{}) (interface{}, error),
+		keys []string,
+		args []interface{}) (interface{}, error) {
+
+		if len(keys) != 1 || len(args) < 1 {
+			return nil, errors.New("wrong number of lock script arguments")
+		}
+		r, err := call("get", keys[0])
+		if err != nil {
+			return nil, err
+		} else if r != args[0] {
+			return 0, nil
+		}
+		return call(cmd, append([]interface{}{keys[0]}, args[1:]...)...)
+	}
+}
+
+func init() {
+	storage.RegisterScriptFunc(lockReleaseEval, lockScriptFunc("del"))
+	storage.RegisterScriptFunc(lockRefreshEval, lockScriptFunc("pexpire"))
+
+	f := pubsub.NewFilter([]string{LockReleaseCmd}, nil)
+	err := watchGlobal(f, func(a *types.Action) {
+		tryLockWaiters(a.StorageKey)
+	})
+	if err != nil {
+		gslog.Fatal(err.Error())
+	}
+	go lockSpin()
+}
+
+func lockSpin() {
+	refresh := time.NewTicker(lockRefresh)
+	retry := time.NewTicker(lockRetryPeriod)
+	for {
+		select {
+		case <-refresh.C:
+			refreshLocks()
+		case <-retry.C:
+			lockLock.Lock()
+			names := make([]string, 0, len(lockWaiters))
+			for name := range lockWaiters {
+				names = append(names, name)
+			}
+			lockLock.Unlock()
+			for _, name := range names {
+				tryLockWaiters(name)
+			}
+		}
+	}
+}
+
+// acquireLock attempts to set the lock in the datastore for the given holder.
+// Returns whether or not the lock was acquired. If the holder disconnected
+// while the lock was being acquired it's released again straight away, since
+// CleanClientLocks may have already run for it.
+func acquireLock(name string, hl *heldLock) (bool, error) {
+	leaseMs := int64(lockLease / time.Millisecond)
+	cmd := storageUnit.NewCommand(
+		"set", lockKeyPrefix+name, hl.token(), "NX", "PX", leaseMs,
+	)
+	r, err := storageUnit.Cmd(lockKeyPrefix+name, cmd)
+	if err != nil || r == nil {
+		return false, err
+	}
+
+	lockLock.Lock()
+	if clientGone(hl.client) {
+		lockLock.Unlock()
+		return false, releaseLock(name, hl)
+	}
+	heldLocks[name] = hl
+	lockLock.Unlock()
+
+	return true, pubLock(LockAcquireCmd, name, hl)
+}
+
+// isWaiting returns whether the client is in line for the lock. lockLock must be
+// held when calling this
+func isWaiting(name string, c stypes.Client) bool {
+	for _, hl := range lockWaiters[name] {
+		if hl.client == c {
+			return true
+		}
+	}
+	return false
+}
+
+// waitersWithout returns the waiters for a lock other than the given client.
+// lockLock must be held when calling this
+func waitersWithout(name string, c stypes.Client) []*heldLock {
+	var kept []*heldLock
+	for _, hl := range lockWaiters[name] {
+		if hl.client != c {
+			kept = append(kept, hl)
+		}
+	}
+	return kept
+}
+
+// setWaiters sets the waiters for a lock, removing its entry if there are none.
+// lockLock must be held when calling this
+func setWaiters(name string, waiters []*heldLock) {
+	if len(waiters) == 0 {
+		delete(lockWaiters, name)
+	} else {
+		lockWaiters[name] = waiters
+	}
+}
+
+func clientGone(c stypes.Client) bool {
+	select {
+	case <-c.GoneCh():
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseLock removes the lock from the datastore if the given holder still
+// holds it, and lets everyone know it's free
+func releaseLock(name string, hl *heldLock) error {
+	lockLock.Lock()
+	delete(heldLocks, name)
+	lockLock.Unlock()
+
+	cmd := storageUnit.NewCommand(
+		"eval", lockReleaseEval, 1, lockKeyPrefix+name, hl.token(),
+	)
+	if _, err := storageUnit.Cmd(lockKeyPrefix+name, cmd); err != nil {
+		return err
+	}
+	if err := pubLock(LockReleaseCmd, name, hl); err != nil {
+		return err
+	}
+
+	// Waiters on this node can be tried right away, rather than waiting for
+	// the release to come back around as a global event
+	go tryLockWaiters(name)
+	return nil
+}
+
+func pubLock(cmd, name string, hl *heldLock) error {
+	return keychanges.PubLocal(&types.Action{
+		Command:    cmd,
+		StorageKey: name,
+		Id:         hl.id,
+		ClientId:   string(hl.client.ClientId().Bytes()),
+	})
+}
+
+func pushToLockHolder(cmd, name string, hl *heldLock) {
+	pushTo(hl.client, &types.Action{Command: cmd, StorageKey: name, Id: hl.id})
+}
+
+// tryLockWaiters attempts to acquire the given lock for the first client on
+// this node waiting on it, if there are any
+func tryLockWaiters(name string) {
+	lockLock.Lock()
+	waiters := lockWaiters[name]
+	if len(waiters) == 0 {
+		lockLock.Unlock()
+		return
+	}
+	hl := waiters[0]
+	setWaiters(name, waiters[1:])
+	lockLock.Unlock()
+
+	ok, err := acquireLock(name, hl)
+	if err != nil {
+		gslog.Errorf("acquiring lock %s for waiter: %s", name, err)
+	}
+	// The client may have asked to wait again while it was out of line, so
+	// any other place it has in line is dropped
+	lockLock.Lock()
+	waiters = waitersWithout(name, hl.client)
+	if !ok && !clientGone(hl.client) {
+		// Put the waiter back at the front of the line
+		waiters = append([]*heldLock{hl}, waiters...)
+	}
+	setWaiters(name, waiters)
+	lockLock.Unlock()
+	if ok {
+		pushToLockHolder(LockAcquireCmd, name, hl)
+	}
+}
+
+// refreshLocks extends the lease on every lock held by a client on this node.
+// If a lock turns out to no longer be held its holder is told it was lost
+func refreshLocks() {
+	lockLock.Lock()
+	held := make(map[string]*heldLock, len(heldLocks))
+	for name, hl := range heldLocks {
+		held[name] = hl
+	}
+	lockLock.Unlock()
+
+	leaseMs := int64(lockLease / time.Millisecond)
+	for name, hl := range held {
+		cmd := storageUnit.NewCommand(
+			"eval", lockRefreshEval, 1, lockKeyPrefix+name, hl.token(), leaseMs,
+		)
+		r, err := storageUnit.Cmd(lockKeyPrefix+name, cmd)
+		if err != nil {
+			gslog.Errorf("refreshing lock %s: %s", name, err)
+			continue
+		} else if i, _ := r.(int); i != 0 {
+			continue
+		}
+
+		gslog.Warnf("Lock %s was lost by its holder", name)
+		lockLock.Lock()
+		if heldLocks[name] == hl {
+			delete(heldLocks, name)
+		}
+		lockLock.Unlock()
+		pushToLockHolder(LockLostCmd, name, hl)
+	}
+}
+
+// LAcquire attempts to acquire the lock named by the key for the client. Returns
+// 1 if the lock was acquired and 0 if not. If "wait" is given as an argument and
+// the lock isn't acquired the client is put in line for it, and will be pushed
+// an lacquire once it's been acquired on its behalf. A client already in line
+// keeps its place.
+func LAcquire(c stypes.Client, cmd *types.Action) (interface{}, error) {
+	wait := false
+	for i := range cmd.Args {
+		if s, _ := cmd.Args[i].(string); strings.ToLower(s) == "wait" {
+			wait = true
+		} else {
+			return nil, unknownLockOpt
+		}
+	}
+
+	name := cmd.StorageKey
+	lockLock.Lock()
+	hl, ok := heldLocks[name]
+	lockLock.Unlock()
+	if ok && hl.client == c {
+		return 1, nil
+	}
+
+	hl = &heldLock{client: c, id: cmd.Id}
+	ok, err := acquireLock(name, hl)
+	if err != nil {
+		return nil, err
+	} else if ok {
+		return 1, nil
+	}
+
+	if wait {
+		lockLock.Lock()
+		if !clientGone(c) && !isWaiting(name, c) {
+			lockWaiters[name] = append(lockWaiters[name], hl)
+		}
+		lockLock.Unlock()
+	}
+	return 0, nil
+}
+
+// LRelease releases the lock named by the key, if the client holds it
+func LRelease(c stypes.Client, cmd *types.Action) (interface{}, error) {
+	name := cmd.StorageKey
+	lockLock.Lock()
+	hl, ok := heldLocks[name]
+	lockLock.Unlock()
+	if !ok || hl.client != c {
+		return nil, notLockHolder
+	}
+	return OK, releaseLock(name, hl)
+}
+
+// LHolder returns information about the holder of the lock named by the key,
+// or nil if no one holds it
+func LHolder(c stypes.Client, cmd *types.Action) (interface{}, error) {
+	key := lockKeyPrefix + cmd.StorageKey
+	r, err := storageUnit.Cmd(key, storageUnit.NewCommand("get", key))
+	if err != nil || r == nil {
+		return nil, err
+	}
+
+	token, _ := r.(string)
+	parts := strings.SplitN(token, lockTokenSepChar, 3)
+	if len(parts) != 3 {
+		return nil, errors.New("malformed lock")
+	}
+	return map[string]string{
+		"node": parts[0],
+		"cid":  parts[1],
+		"id":   parts[2],
+	}, nil
+}
+
+// CleanClientLocks releases all locks held by the given client and removes it
+// from the line for any locks it's waiting on. The client's GoneCh must already
+// be closed, so that it isn't given any more locks afterwards
+func CleanClientLocks(c stypes.Client) error {
+	lockLock.Lock()
+	held := map[string]*heldLock{}
+	for name, hl := range heldLocks {
+		if hl.client == c {
+			held[name] = hl
+		}
+	}
+	for name := range lockWaiters {
+		setWaiters(name, waitersWithout(name, c))
+	}
+	lockLock.Unlock()
+
+	for name, hl := range held {
+		if err := releaseLock(name, hl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,17 +1,71 @@
 package builtin
 
 import (
+	"errors"
+	"strings"
+
 	"github.com/mediocregopher/hyrax/server/core/keychanges"
+	"github.com/mediocregopher/hyrax/server/pubsub"
 	stypes "github.com/mediocregopher/hyrax/server/types"
 	"github.com/mediocregopher/hyrax/types"
 )
 
+var unknownFilterOpt = errors.New("filter args must start with cmds or ids")
+
+// argsToFilter parses the optional filter arguments which can be given to the
+// mon commands. They take the form of a "cmds" and/or an "ids" argument, each
+// followed by the values for it, e.g. ["cmds","rpush","lpush","ids","gopher"].
+// If allowInternal is set an "internal" argument may also be given, which lets
+// internal events through. Returns nil if no filter arguments were given
+func argsToFilter(
+	cmd *types.Action, allowInternal bool) (*pubsub.Filter, error) {
+
+	var cmds, ids []string
+	var cur *[]string
+	internal := false
+	for i := range cmd.Args {
+		s, ok := cmd.Args[i].(string)
+		if !ok {
+			return nil, wrongArgType
+		}
+		switch strings.ToLower(s) {
+		case "cmds":
+			cur = &cmds
+		case "ids":
+			cur = &ids
+		case "internal":
+			if !allowInternal {
+				return nil, unknownFilterOpt
+			}
+			internal = true
+			cur = nil
+		default:
+			if cur == nil {
+				return nil, unknownFilterOpt
+			}
+			*cur = append(*cur, s)
+		}
+	}
+	f := pubsub.NewFilter(cmds, ids)
+	if internal {
+		if f == nil {
+			f = &pubsub.Filter{}
+		}
+		f.Internal = true
+	}
+	return f, nil
+}
+
 // MGlobal adds the client to the set of clients that are monitoring key changes
 // happening all over the cluster. This hooks into a separate funtionality than
 // the normal mon commands, so it will stack with them (aka, duplicate pushes if
 // you also monitor individual keys)
 func MGlobal(c stypes.Client, cmd *types.Action) (interface{}, error) {
-	return OK, keychanges.SubscribeGlobal(c)
+	f, err := argsToFilter(cmd, true)
+	if err != nil {
+		return nil, err
+	}
+	return OK, keychanges.SubscribeGlobal(c, f)
 }
 
 // MLocal adds the client to the set of clients that are monitoring key changes
@@ -19,14 +73,37 @@ func MGlobal(c stypes.Client, cmd *types.Action) (interface{}, error) {
 // funtionality than the normal mon commands, so it will stack with them (aka,
 // duplicate pushes if you also monitor individual keys)
 func MLocal(c stypes.Client, cmd *types.Action) (interface{}, error) {
-	return OK, keychanges.SubscribeLocal(c)
+	f, err := argsToFilter(cmd, true)
+	if err != nil {
+		return nil, err
+	}
+	return OK, keychanges.SubscribeLocal(c, f)
+}
+
+// MGlobalRem removes the client from the set of clients that are monitoring key
+// changes happening all over the cluster
+func MGlobalRem(c stypes.Client, cmd *types.Action) (interface{}, error) {
+	return OK, keychanges.UnsubscribeGlobal(c)
+}
+
+// MLocalRem removes the client from the set of clients that are monitoring key
+// changes happening on this node of the cluster
+func MLocalRem(c stypes.Client, cmd *types.Action) (interface{}, error) {
+	return OK, keychanges.UnsubscribeLocal(c)
 }
 
 //MAdd adds the client to the set of clients that are monitoring the key (so it
 //can receive alerts) and adds the key to the set of keys that the client is
 //monitoring (so it can clean up)
 func MAdd(c stypes.Client, cmd *types.Action) (interface{}, error) {
-	return OK, keychanges.Mon(c, cmd.StorageKey)
+	if KeyIsReserved(cmd.StorageKey) {
+		return nil, ReservedKeyErr
+	}
+	f, err := argsToFilter(cmd, false)
+	if err != nil {
+		return nil, err
+	}
+	return OK, keychanges.Mon(c, f, cmd.StorageKey)
 }
 
 // MRem removes the client from the set of clients that are monitoring the key,
@@ -34,3 +111,29 @@ func MAdd(c stypes.Client, cmd *types.Action) (interface{}, error) {
 func MRem(c stypes.Client, cmd *types.Action) (interface{}, error) {
 	return OK, keychanges.Unmon(c, cmd.StorageKey)
 }
+
+// MSubs returns everything the client is currently subscribed to: the keys it
+// is monitoring, whether it's monitoring global or local key changes, and the
+// ekgs it's on along with the ids it's using for them
+func MSubs(c stypes.Client, cmd *types.Action) (interface{}, error) {
+	keys, err := keychanges.Mons(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ekgs, ids, err := EkgsForClient(c)
+	if err != nil {
+		return nil, err
+	}
+	ekgsM := make(map[string]string, len(ekgs))
+	for i := range ekgs {
+		ekgsM[ekgs[i]] = ids[i]
+	}
+
+	return map[string]interface{}{
+		"keys":   keys,
+		"global": keychanges.IsSubscribedGlobal(c),
+		"local":  keychanges.IsSubscribedLocal(c),
+		"ekgs":   ekgsM,
+	}, nil
+}
@@ -0,0 +1,127 @@
+package builtin
+
+import (
+	"errors"
+	"github.com/grooveshark/golib/gslog"
+
+	"github.com/mediocregopher/hyrax/server/core/keychanges"
+	"github.com/mediocregopher/hyrax/server/pubsub"
+	stypes "github.com/mediocregopher/hyrax/server/types"
+	"github.com/mediocregopher/hyrax/types"
+)
+
+// SendCmd is the command of messages sent directly to an ekg member
+var SendCmd = "esend"
+
+// The reserved key which messages to members on other nodes are published under
+var sendKey = reservedKeyPrefix + SendCmd
+
+var noEkgMember = errors.New("no such ekg member")
+
+// Messages to members on other nodes go out as internal events, so only the
+// member's node delivers them and no client sees them on the way
+func init() {
+	pubsub.AddInternalCommand(SendCmd)
+	f := pubsub.NewFilter([]string{SendCmd}, nil)
+	if err := watchGlobal(f, handleRemoteSend); err != nil {
+		gslog.Fatal(err.Error())
+	}
+}
+
+// MPub publishes its args as a key change event on the key, without touching
+// the datastore. All clients in the cluster monitoring the key will be pushed
+// the command as-is. The publishing is done by the normal modifies path, so
+// this doesn't actually have to do anything
+func MPub(_ stypes.Client, cmd *types.Action) (interface{}, error) {
+	return OK, nil
+}
+
+// ESend sends its args, minus the first two, to a single member of the ekg
+// named by the key. The first two args are the id of the node the member is
+// connected to and its connection id on that node, as returned by emembers.
+// Messages to members on other nodes go out to the whole cluster as an internal
+// event under a reserved key, which the member's node picks up and delivers.
+func ESend(c stypes.Client, cmd *types.Action) (interface{}, error) {
+	if len(cmd.Args) < 2 {
+		return nil, wrongNumArgs
+	}
+	node, ok1 := cmd.Args[0].(string)
+	cid, ok2 := cmd.Args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, wrongArgType
+	}
+
+	msg := &types.Action{
+		Command:    SendCmd,
+		StorageKey: cmd.StorageKey,
+		Id:         cmd.Id,
+		Args:       cmd.Args[2:],
+		Origin:     stypes.NodeId,
+		ClientId:   string(c.ClientId().Bytes()),
+	}
+	if node == stypes.NodeId {
+		if !deliverSend(cid, msg) {
+			return nil, noEkgMember
+		}
+		return OK, nil
+	}
+
+	args := make([]interface{}, 0, len(cmd.Args)+1)
+	args = append(args, cmd.StorageKey)
+	args = append(args, cmd.Args...)
+	fwd := &types.Action{
+		Command:    SendCmd,
+		StorageKey: sendKey,
+		Id:         cmd.Id,
+		Args:       args,
+		ClientId:   msg.ClientId,
+	}
+	return OK, keychanges.PubLocal(fwd)
+}
+
+// handleRemoteSend takes an esend event from another node and, if the member
+// it's meant for is on this node, delivers it. The event's args are the ekg,
+// node id and connection id of the member, followed by the message.
+func handleRemoteSend(a *types.Action) {
+	if len(a.Args) < 3 {
+		return
+	}
+	key, _ := a.Args[0].(string)
+	node, _ := a.Args[1].(string)
+	cid, _ := a.Args[2].(string)
+	if node != stypes.NodeId {
+		return
+	}
+
+	msg := &types.Action{
+		Command:    SendCmd,
+		StorageKey: key,
+		Id:         a.Id,
+		Args:       a.Args[3:],
+		Origin:     a.Origin,
+		ClientId:   a.ClientId,
+	}
+	if !deliverSend(cid, msg) {
+		gslog.Debugf("Dropping %v, no such ekg member", a)
+	}
+}
+
+// deliverSend pushes the message to the member of the ekg named by its key
+// with the given connection id on this node. Returns false if there's no such
+// member
+func deliverSend(cid string, msg *types.Action) bool {
+	cidT, err := stypes.ClientIdFromBytes([]byte(cid))
+	if err != nil {
+		return false
+	}
+
+	ekgLock.RLock()
+	m, ok := ekgKeyToMembers[msg.StorageKey][cidT.Uint64()]
+	ekgLock.RUnlock()
+	if !ok || m.client == nil {
+		return false
+	}
+
+	go pushTo(m.client, msg)
+	return true
+}
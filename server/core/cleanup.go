@@ -4,11 +4,8 @@ import (
 	"github.com/mediocregopher/hyrax/server/core/builtin"
 	"github.com/mediocregopher/hyrax/server/core/keychanges"
 	stypes "github.com/mediocregopher/hyrax/server/types"
-	"github.com/mediocregopher/hyrax/types"
 )
 
-var closedCmd = "eclose"
-
 // ClientClosed takes care of all cleanup that's necessary when a client has
 // closed
 func ClientClosed(c stypes.Client) error {
@@ -18,11 +15,7 @@ func ClientClosed(c stypes.Client) error {
 	}
 
 	for i := range ekgs {
-		cmd := &types.Action{
-			Command:    closedCmd,
-			StorageKey: ekgs[i],
-			Id:         ids[i],
-		}
+		cmd := builtin.ClosedAction(ekgs[i], ids[i], c.ClientId())
 		if err := keychanges.PubLocal(cmd); err != nil {
 			return err
 		}
@@ -32,5 +25,9 @@ func ClientClosed(c stypes.Client) error {
 		return err
 	}
 
+	if err := builtin.CleanClientLocks(c); err != nil {
+		return err
+	}
+
 	return keychanges.UnsubscribeAll(c)
 }
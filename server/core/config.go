@@ -36,6 +36,14 @@ func InitialConfigure() error {
 		return err
 	}
 
+	if err := SetupScripts(); err != nil {
+		return err
+	}
+
+	if err := SetupStorageNotify(); err != nil {
+		return err
+	}
+
 	listens := config.ListenEndpoints
 	for i := range listens {
 		if err := listenHandler(listens[i]); err != nil {
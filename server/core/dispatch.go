@@ -2,7 +2,10 @@ package core
 
 import (
 	"errors"
+	"fmt"
 	"github.com/grooveshark/golib/gslog"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/mediocregopher/hyrax/server/auth"
@@ -11,30 +14,84 @@ import (
 	"github.com/mediocregopher/hyrax/server/core/keychanges"
 	"github.com/mediocregopher/hyrax/server/listen"
 	"github.com/mediocregopher/hyrax/server/storage"
+	"github.com/mediocregopher/hyrax/server/storage/boltdb"
+	"github.com/mediocregopher/hyrax/server/storage/mem"
 	"github.com/mediocregopher/hyrax/server/storage/redis"
 	stypes "github.com/mediocregopher/hyrax/server/types"
 	"github.com/mediocregopher/hyrax/types"
 )
 
 // The set of connections into the actual data store
-var storageUnit *storage.StorageUnit
+var storageUnit *storage.ShardedStorageUnit
 
-// The number of connections in the storage unit
+// The number of connections to each shard in the storage unit
 const UNITSIZE = 10
 
 func SetupStorage() error {
-	// We assume redis for now since it's the only available type
-	addr := config.StorageInfo
-	gslog.Infof("Connecting to datastore at %s", addr)
-	sucs := make([]storage.Storage, UNITSIZE)
-	for i := range sucs {
-		sucs[i] = redis.New()
-	}
-	su, err := storage.NewStorageUnit(sucs, "tcp", addr)
+	var newFn func() storage.Storage
+	switch config.StorageType {
+	case "redis":
+		newFn = redis.New
+	case "memory":
+		newFn = mem.New
+	case "bolt":
+		newFn = boltdb.New
+	default:
+		return fmt.Errorf("unknown storage-type: %s", config.StorageType)
+	}
+
+	if config.StorageCommands != "" {
+		if config.StorageType != "redis" {
+			return fmt.Errorf(
+				"storage-commands is not supported by storage-type %s",
+				config.StorageType,
+			)
+		}
+		if err := redis.LoadCommands(config.StorageCommands); err != nil {
+			return err
+		}
+	}
+
+	var extra []interface{}
+	if len(config.StorageSentinels) > 0 {
+		if config.StorageType != "redis" {
+			return fmt.Errorf(
+				"storage-sentinel is not supported by storage-type %s",
+				config.StorageType,
+			)
+		}
+		extra = append(extra, config.StorageSentinels)
+	}
+
+	addrs := config.StorageShards
+	gslog.Infof("Connecting to %s datastore at %v", config.StorageType, addrs)
+	su, err := storage.NewShardedStorageUnit(
+		newFn, UNITSIZE, "tcp", addrs, extra...,
+	)
 	if err != nil {
 		return err
 	}
+	for _, raddrs := range config.StorageReplicas {
+		if config.StorageType != "redis" {
+			su.Close()
+			return fmt.Errorf(
+				"storage-replica is not supported by storage-type %s",
+				config.StorageType,
+			)
+		}
+		addrs := strings.Split(raddrs, ",")
+		for i := range addrs {
+			addrs[i] = strings.TrimSpace(addrs[i])
+		}
+		gslog.Infof("Connecting to %s datastore replicas at %v",
+			config.StorageType, addrs)
+		if err := su.AddReplicas(newFn, UNITSIZE, "tcp", addrs); err != nil {
+			su.Close()
+			return err
+		}
+	}
 	storageUnit = su
+	builtin.SetStorageUnit(su)
 
 	return nil
 }
@@ -55,6 +112,9 @@ func init() {
 }
 
 func handleActionWrap(aw *listen.ActionWrap) {
+	if storageUnit.CommandBlocks(aw.Action.Command) {
+		close(aw.BlockingCh)
+	}
 	ar := RunAction(aw.Client, aw.Action)
 	select {
 	case aw.ActionReturnCh <- ar:
@@ -87,12 +147,24 @@ func RunAction(c stypes.Client, cmd *types.Action) *types.ActionReturn {
 
 func dispatchCommand(c stypes.Client, cmd *types.Action) (interface{}, error) {
 
+	if s, ok := getScript(cmd.Command); ok {
+		if s.modifies {
+			if err := s.auth(cmd); err != nil {
+				return nil, err
+			}
+		}
+		scrubAction(c, cmd)
+		return dispatchScript(s, c, cmd)
+	}
+
 	var modifies, isAdmin func(string) bool
 	var dispatch func(stypes.Client, *types.Action) (interface{}, error)
+	selfPubs := false
 	if builtin.CommandIsBuiltIn(cmd.Command) {
 		modifies = builtin.BuiltInCommandModifies
 		isAdmin = builtin.BuiltInIsAdmin
 		dispatch = builtin.GetBuiltInFunc(cmd.Command)
+		selfPubs = builtin.BuiltInSelfPublishes(cmd.Command)
 	} else if storageUnit.CommandAllowed(cmd.Command) {
 		modifies = storageUnit.CommandModifies
 		isAdmin = storageUnit.CommandIsAdmin
@@ -111,27 +183,119 @@ func dispatchCommand(c stypes.Client, cmd *types.Action) (interface{}, error) {
 			return nil, err
 		}
 	}
-	// Before this cmd can get sent outside this go-routine we want to make sure
-	// the secret is cleared
-	cmd.Secret = ""
+	scrubAction(c, cmd)
 
 	r, err := dispatch(c, cmd)
-	if err == nil && mods && !adm {
+	if err == nil && mods && !adm && !selfPubs {
 		keychanges.PubLocal(cmd)
 	}
 
 	return r, err
 }
 
+// scrubAction is called before an action can get sent outside the go-routine
+// handling it, to make sure the secret is cleared and that the client hasn't
+// tried to pass off its own event tags
+func scrubAction(c stypes.Client, cmd *types.Action) {
+	cmd.Secret = ""
+	cmd.Origin = ""
+	cmd.EventId = 0
+	cmd.ClientId = string(c.ClientId().Bytes())
+}
+
 // dispatchStorageCmd takes a client and a client command, and runs the command
-// directly on the storage unit
+// directly on the storage unit. Commands which don't modify anything are sent to
+// a replica, unless the action asks for a consistent read. Blocking commands are
+// cancelled if the client closes while they're waiting
 func dispatchStorageCmd(
 	c stypes.Client,
 	cmd *types.Action) (interface{}, error) {
 
+	if builtin.KeyIsReserved(cmd.StorageKey) {
+		return nil, builtin.ReservedKeyErr
+	}
 	args := make([]interface{}, 1, len(cmd.Args)+1)
 	args[0] = cmd.StorageKey
-	args = append(args, cmd.Args...)
+	args = append(args, storageArgs(cmd.Args)...)
 	dcmd := storageUnit.NewCommand(cmd.Command, args...)
-	return storageUnit.Cmd(dcmd)
+
+	var r interface{}
+	var err error
+	if storageUnit.CommandBlocks(cmd.Command) {
+		markWrite(cmd.StorageKey)
+		r, err = storageUnit.BlockingCmd(cmd.StorageKey, dcmd, c.GoneCh())
+	} else if storageUnit.CommandModifies(cmd.Command) {
+		markWrite(cmd.StorageKey)
+		r, err = storageUnit.Cmd(cmd.StorageKey, dcmd)
+	} else if cmd.Consistent {
+		r, err = storageUnit.Cmd(cmd.StorageKey, dcmd)
+	} else {
+		r, err = storageUnit.ReadCmd(cmd.StorageKey, dcmd)
+	}
+	if err == nil && cmd.Binary {
+		r = binaryReply(r)
+	}
+	return r, err
+}
+
+// storageArgs returns a copy of the given args with any Binary values turned
+// into plain []byte, which is what the datastores know how to send as is
+func storageArgs(args []interface{}) []interface{} {
+	sargs := make([]interface{}, len(args))
+	for i := range args {
+		if b, ok := args[i].(types.Binary); ok {
+			sargs[i] = []byte(b)
+		} else {
+			sargs[i] = args[i]
+		}
+	}
+	return sargs
+}
+
+// binaryReply returns the reply from the datastore with every string in it
+// turned into Binary, at any depth. Status replies are left alone. Maps are
+// turned into flat lists of field/value pairs (sorted by field) so that their
+// fields can be Binary too.
+func binaryReply(r interface{}) interface{} {
+	switch rt := r.(type) {
+	case string:
+		return types.Binary(rt)
+	case []byte:
+		return types.Binary(rt)
+	case []string:
+		l := make([]interface{}, len(rt))
+		for i := range rt {
+			l[i] = types.Binary(rt[i])
+		}
+		return l
+	case []interface{}:
+		l := make([]interface{}, len(rt))
+		for i := range rt {
+			l[i] = binaryReply(rt[i])
+		}
+		return l
+	case map[string]string:
+		fields := make([]string, 0, len(rt))
+		for k := range rt {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+		l := make([]interface{}, 0, 2*len(rt))
+		for _, k := range fields {
+			l = append(l, types.Binary(k), types.Binary(rt[k]))
+		}
+		return l
+	case map[string]interface{}:
+		fields := make([]string, 0, len(rt))
+		for k := range rt {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+		l := make([]interface{}, 0, 2*len(rt))
+		for _, k := range fields {
+			l = append(l, types.Binary(k), binaryReply(rt[k]))
+		}
+		return l
+	}
+	return r
 }
@@ -2,6 +2,7 @@ package dist
 
 import (
 	"github.com/grooveshark/golib/gslog"
+	"sync"
 	"time"
 
 	"github.com/mediocregopher/hyrax/server/config"
@@ -10,12 +11,13 @@ import (
 	"github.com/mediocregopher/hyrax/types"
 )
 
-// Manager for connections to other nodes we are pulling global events from
-var PullFromGlobalManager = dist.New("MGLOBAL")
+// Manager for connections to other nodes we are pulling global events from.
+// Internal events are pulled too, since they're meant for this node
+var PullFromGlobalManager = dist.New("MGLOBAL", "internal")
 
 // Manager for connections to other nodes we are pulling local events from
 // (these come from other nodes calling ALISTENTOME)
-var PullFromLocalManager = dist.NewTimeout(10*time.Second, "MLOCAL")
+var PullFromLocalManager = dist.NewTimeout(10*time.Second, "MLOCAL", "internal")
 
 // Manager for connection to other nodes we are calling ALISTENTOME on,
 // effectively commanding them to pull local events from us
@@ -36,10 +38,10 @@ func clusterSpin() {
 		select {
 		case a = <-PullFromGlobalManager.PushCh:
 			gslog.Debugf("Got %v from global", a)
-			err = keychanges.PubGlobal(a)
+			err = pubGlobal(a)
 		case a = <-PullFromLocalManager.PushCh:
 			gslog.Debugf("Got %v from local", a)
-			err = keychanges.PubGlobal(a)
+			err = pubGlobal(a)
 		case _ = <-PushToManager.PushCh:
 		}
 
@@ -49,15 +51,51 @@ func clusterSpin() {
 	}
 }
 
+// pubGlobal publishes the given event globally on this node, unless this node
+// has already seen it. With a misconfigured topology events could otherwise
+// bounce between nodes forever, or be delivered to clients more than once
+func pubGlobal(a *types.Action) error {
+	if !firstSeen(a) {
+		gslog.Debugf("Dropping already seen event %v", a)
+		return nil
+	}
+	return keychanges.PubGlobal(a)
+}
+
+// Clusterize can be called both on reload and when gossip sees the cluster
+// change, so only one call is let through at a time
+var clusterizeLock sync.Mutex
+
 // Reads the cluster information from the config and attempts to set it up. If
 // this isn't the first time this function has been called it will do a diff and
 // open/close whatever connections are needed, and leave the remaining ones
-// untouched.
+// untouched. Nodes discovered through gossip are pushed to along with the
+// configured push-to endpoints.
+//
+// In mesh mode every node this node knows about, configured or discovered,
+// is pushed to and nothing is pulled from. Since every node then gets every
+// other node's local events directly from it, and local events are never
+// passed on, each event makes exactly one hop to each node.
 func Clusterize() error {
+	clusterizeLock.Lock()
+	defer clusterizeLock.Unlock()
+
+	pullFrom := config.PullFromEndpoints
+	pushTo := unionEndpoints(config.PushToEndpoints, GossipEndpoints())
+	if config.Topology == config.TopologyMesh {
+		pullFrom = nil
+		pushTo = unionEndpoints(
+			[]*types.ListenEndpoint{config.MyEndpoint},
+			pushTo,
+			config.PullFromEndpoints,
+		)
+	}
+
 	err := resetManager(
 		PullFromGlobalManager,
-		config.PullFromEndpoints,
+		pullFrom,
 		"MGLOBAL",
+		"internal",
 	)
 	if err != nil {
 		return err
@@ -65,7 +103,7 @@ func Clusterize() error {
 
 	err = resetManager(
 		PushToManager,
-		config.PushToEndpoints,
+		pushTo,
 		"ALISTENTOME",
 		config.MyEndpoint.String(),
 	)
@@ -76,6 +114,22 @@ func Clusterize() error {
 	return nil
 }
 
+// unionEndpoints returns all the endpoints in the given lists, with duplicates
+// removed
+func unionEndpoints(lesLists ...[]*types.ListenEndpoint) []*types.ListenEndpoint {
+	les := []*types.ListenEndpoint{}
+	lesM := map[string]bool{}
+	for _, list := range lesLists {
+		for _, le := range list {
+			if leStr := le.String(); !lesM[leStr] {
+				lesM[leStr] = true
+				les = append(les, le)
+			}
+		}
+	}
+	return les
+}
+
 func resetManager(
 	m *dist.Manager,
 	les []*types.ListenEndpoint,
@@ -0,0 +1,312 @@
+package dist
+
+import (
+	"errors"
+	"github.com/grooveshark/golib/gslog"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/hyrax/client"
+	"github.com/mediocregopher/hyrax/server/config"
+	"github.com/mediocregopher/hyrax/types"
+)
+
+// If gossip is enabled, every gossipPeriod this node bumps its own heartbeat
+// and, if it has seeds, swaps what it knows about the cluster with a random
+// node it knows about (or one of its seeds). A node whose heartbeat hasn't gone
+// up in gossipFailAfter is assumed to be dead, and is forgotten about entirely
+// after gossipForgetAfter, so that stale heartbeats for it from other nodes
+// don't bring it back.
+const (
+	gossipPeriod      = 1 * time.Second
+	gossipFailAfter   = 10 * time.Second
+	gossipForgetAfter = 3 * gossipFailAfter
+	gossipCmd         = "AGOSSIP"
+)
+
+var badGossip = errors.New("malformed gossip")
+var gossipDisabled = errors.New("gossip is disabled on this node")
+
+// What this node knows about another node in the cluster. The incarnation is
+// when the node started up, so that a node which restarts (and so starts its
+// heartbeat over) is newer than what's known about it from before
+type gossipMember struct {
+	incarnation uint64
+	heartbeat   uint64
+	updated     time.Time
+}
+
+// newer returns whether the given incarnation and heartbeat are more recent
+// than what's known about the member
+func (gm *gossipMember) newer(inc, hb uint64) bool {
+	return inc > gm.incarnation || (inc == gm.incarnation && hb > gm.heartbeat)
+}
+
+func (gm *gossipMember) alive() bool {
+	return time.Since(gm.updated) < gossipFailAfter
+}
+
+// A mapping of the endpoints of other nodes in the cluster to what this node
+// knows about them, and this node's own heartbeat. Both use gossipLock
+var gossipMembers = map[string]*gossipMember{}
+var gossipHeartbeat uint64
+var gossipLock sync.Mutex
+
+// This node's incarnation, in milliseconds so it survives being sent as a json
+// number
+var gossipIncarnation = uint64(time.Now().UnixNano() / int64(time.Millisecond))
+
+// The set of endpoints which were alive the last time the cluster was set up.
+// Only touched by gossipSpin
+var gossipAlive = map[string]bool{}
+
+// Clients connected to the nodes this node has gossiped with, kept so that a
+// new connection doesn't have to be made every round. Only touched by
+// gossipSpin
+var gossipClients = map[string]client.Client{}
+
+func init() {
+	go gossipSpin()
+}
+
+func gossipSpin() {
+	for _ = range time.Tick(gossipPeriod) {
+		if config.Gossip {
+			gossipRound()
+		} else {
+			// Gossip may have been turned off in a reload
+			gossipLock.Lock()
+			gossipMembers = map[string]*gossipMember{}
+			gossipLock.Unlock()
+		}
+		pruneGossipClients()
+
+		alive := gossipAliveSet()
+		if !sameSet(alive, gossipAlive) {
+			gossipAlive = alive
+			gslog.Infof("Gossip cluster membership changed: %v", alive)
+			if err := Clusterize(); err != nil {
+				gslog.Errorf("Clusterize after gossip: %s", err)
+			}
+		}
+	}
+}
+
+// gossipRound bumps this node's heartbeat and forgets about long dead nodes. If
+// this node has seeds it also does a single exchange of gossip with a random
+// node, otherwise it only learns about the cluster from nodes gossiping with it
+func gossipRound() {
+	gossipLock.Lock()
+	gossipHeartbeat++
+	me := config.MyEndpoint.String()
+	candidates := make([]string, 0, len(gossipMembers)+len(config.GossipSeeds))
+	for leStr, gm := range gossipMembers {
+		if gm.alive() {
+			candidates = append(candidates, leStr)
+		}
+	}
+	for _, seed := range config.GossipSeeds {
+		if leStr := seed.String(); leStr != me {
+			candidates = append(candidates, leStr)
+		}
+	}
+	for leStr, gm := range gossipMembers {
+		if time.Since(gm.updated) >= gossipForgetAfter {
+			gslog.Infof("Forgetting about node %s", leStr)
+			delete(gossipMembers, leStr)
+		}
+	}
+	gossipLock.Unlock()
+
+	if len(config.GossipSeeds) == 0 || len(candidates) == 0 {
+		return
+	}
+	leStr := candidates[rand.Intn(len(candidates))]
+	if err := gossipWith(leStr); err != nil {
+		gslog.Debugf("Gossiping with %s: %s", leStr, err)
+	}
+}
+
+// gossipWith does a single exchange of gossip with the node, using the cached
+// client for it if there is one. If anything goes wrong the client is closed,
+// and a new one is made next time
+func gossipWith(leStr string) error {
+	cl, ok := gossipClients[leStr]
+	if !ok {
+		le, err := types.ListenEndpointFromString(leStr)
+		if err != nil {
+			return err
+		}
+		if cl, err = client.NewClient(le, nil); err != nil {
+			return err
+		}
+		gossipClients[leStr] = cl
+	}
+
+	secret := config.InteractionSecret
+	cmd := client.CreateAction(gossipCmd, "", "", secret, gossipList()...)
+	r, err := cl.Cmd(cmd)
+	if err == nil {
+		args, ok := r.([]interface{})
+		if !ok {
+			err = badGossip
+		} else {
+			err = mergeGossip(args)
+		}
+	}
+	if err != nil {
+		cl.Close()
+		delete(gossipClients, leStr)
+	}
+	return err
+}
+
+// pruneGossipClients closes the cached clients of nodes which are no longer
+// members or seeds, e.g. because they've been forgotten about or gossip was
+// turned off
+func pruneGossipClients() {
+	keep := map[string]bool{}
+	if config.Gossip {
+		gossipLock.Lock()
+		for leStr := range gossipMembers {
+			keep[leStr] = true
+		}
+		gossipLock.Unlock()
+		for _, seed := range config.GossipSeeds {
+			keep[seed.String()] = true
+		}
+	}
+	for leStr, cl := range gossipClients {
+		if !keep[leStr] {
+			cl.Close()
+			delete(gossipClients, leStr)
+		}
+	}
+}
+
+// gossipList returns this node's view of the cluster as a flat list of
+// endpoint, incarnation, heartbeat triples. Nodes which are believed to be dead
+// are left off
+func gossipList() []interface{} {
+	gossipLock.Lock()
+	defer gossipLock.Unlock()
+	list := make([]interface{}, 0, 3*len(gossipMembers)+3)
+	list = append(list,
+		config.MyEndpoint.String(), gossipIncarnation, gossipHeartbeat,
+	)
+	for leStr, gm := range gossipMembers {
+		if gm.alive() {
+			list = append(list, leStr, gm.incarnation, gm.heartbeat)
+		}
+	}
+	return list
+}
+
+func argToUint64(arg interface{}) (uint64, error) {
+	switch argt := arg.(type) {
+	case uint64:
+		return argt, nil
+	case int64:
+		return uint64(argt), nil
+	case float64:
+		return uint64(argt), nil
+	case string:
+		return strconv.ParseUint(argt, 10, 64)
+	}
+	return 0, badGossip
+}
+
+// mergeGossip takes in another node's view of the cluster, as returned by
+// gossipList, and updates this node's view with anything newer in it
+func mergeGossip(args []interface{}) error {
+	if len(args)%3 != 0 {
+		return badGossip
+	}
+	me := config.MyEndpoint.String()
+
+	gossipLock.Lock()
+	defer gossipLock.Unlock()
+	for i := 0; i < len(args); i += 3 {
+		leStr, ok := args[i].(string)
+		if !ok {
+			return badGossip
+		}
+		if _, err := types.ListenEndpointFromString(leStr); err != nil {
+			return err
+		}
+		inc, err := argToUint64(args[i+1])
+		if err != nil {
+			return err
+		}
+		hb, err := argToUint64(args[i+2])
+		if err != nil {
+			return err
+		}
+		if leStr == me {
+			continue
+		}
+		if gm, ok := gossipMembers[leStr]; !ok {
+			gslog.Infof("Discovered node %s through gossip", leStr)
+			gossipMembers[leStr] = &gossipMember{inc, hb, time.Now()}
+		} else if gm.newer(inc, hb) {
+			if inc != gm.incarnation {
+				gslog.Infof("Node %s restarted", leStr)
+			}
+			gm.incarnation = inc
+			gm.heartbeat = hb
+			gm.updated = time.Now()
+		}
+	}
+	return nil
+}
+
+// Gossip merges in the view of the cluster sent by another node and returns
+// this node's view in turn
+func Gossip(args []interface{}) ([]interface{}, error) {
+	if !config.Gossip {
+		return nil, gossipDisabled
+	}
+	if err := mergeGossip(args); err != nil {
+		return nil, err
+	}
+	return gossipList(), nil
+}
+
+func gossipAliveSet() map[string]bool {
+	gossipLock.Lock()
+	defer gossipLock.Unlock()
+	alive := map[string]bool{}
+	for leStr, gm := range gossipMembers {
+		if gm.alive() {
+			alive[leStr] = true
+		}
+	}
+	return alive
+}
+
+// GossipEndpoints returns the endpoints of all other nodes which gossip
+// currently believes are alive
+func GossipEndpoints() []*types.ListenEndpoint {
+	alive := gossipAliveSet()
+	les := make([]*types.ListenEndpoint, 0, len(alive))
+	for leStr := range alive {
+		if le, err := types.ListenEndpointFromString(leStr); err == nil {
+			les = append(les, le)
+		}
+	}
+	return les
+}
+
+func sameSet(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package dist
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/hyrax/types"
+)
+
+// How long an event is remembered for after it's first seen. Any copies of the
+// event which come in during that time are dropped
+const seenPeriod = 30 * time.Second
+
+// Events seen in the current and previous periods. Every seenPeriod cur becomes
+// prev and a new cur is made, so an event is remembered for between one and two
+// periods
+var seenCur = map[string]bool{}
+var seenPrev = map[string]bool{}
+var seenLock sync.Mutex
+
+func init() {
+	go func() {
+		for _ = range time.Tick(seenPeriod) {
+			seenLock.Lock()
+			seenPrev = seenCur
+			seenCur = map[string]bool{}
+			seenLock.Unlock()
+		}
+	}()
+}
+
+// firstSeen returns whether or not this is the first time the given event has
+// been seen by this node, and records it as seen. An event is only ever
+// delivered once, so this also covers events which originated on this node
+// coming back to it. Events without an Origin (e.g. from nodes which don't tag
+// their events) can't be tracked and are always considered to be first seen.
+func firstSeen(a *types.Action) bool {
+	if a.Origin == "" {
+		return true
+	}
+	id := a.Origin + ":" + strconv.FormatUint(a.EventId, 10)
+
+	seenLock.Lock()
+	defer seenLock.Unlock()
+	if seenCur[id] || seenPrev[id] {
+		return false
+	}
+	seenCur[id] = true
+	return true
+}
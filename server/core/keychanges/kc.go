@@ -1,6 +1,8 @@
 package keychanges
 
 import (
+	"sync/atomic"
+
 	"github.com/mediocregopher/hyrax/server/pubsub"
 	stypes "github.com/mediocregopher/hyrax/server/types"
 	"github.com/mediocregopher/hyrax/types"
@@ -14,10 +16,15 @@ var global = pubsub.New()
 var local = pubsub.New()
 var mon = pubsub.New()
 
+// Incremented for every key change event originating on this node, used to
+// give each one a unique EventId
+var eventCounter uint64
+
 // Subscribes a client to global key change events. These are events which are
-// being broadcast out to every node in the cluster.
-func SubscribeGlobal(cl stypes.Client) error {
-	return global.Subscribe(cl, single)
+// being broadcast out to every node in the cluster. f may be nil to receive
+// every event.
+func SubscribeGlobal(cl stypes.Client, f *pubsub.Filter) error {
+	return global.SubscribeFiltered(cl, f, single)
 }
 
 // Unsubscribes a client from receiving global key change events, if it was
@@ -26,20 +33,41 @@ func UnsubscribeGlobal(cl stypes.Client) error {
 	return global.Unsubscribe(cl, single)
 }
 
+// Returns whether or not a client is subscribed to global key change events
+func IsSubscribedGlobal(cl stypes.Client) bool {
+	return global.IsSubscribed(cl, single)
+}
+
+// Returns the number of clients subscribed to global key change events
+func GlobalCount() int {
+	return global.SubscriberCount(single)
+}
+
 // Publishes a key change globally, both to those subscribed to global key
-// changes and those subscribed (mon'd) to the actual key being changed
+// changes and those subscribed (mon'd) to the actual key being changed. Events
+// with no key are only published to those subscribed to global key changes
 func PubGlobal(a *types.Action) error {
 	if err := global.Publish(a, single); err != nil {
 		return err
 	}
 
+	if a.StorageKey == "" {
+		return nil
+	}
+	return mon.Publish(a, a.StorageKey)
+}
+
+// Publishes a key change only to those subscribed (mon'd) to the actual key
+// being changed. This is for events which every node generates for itself, and
+// so shouldn't be passed on to other nodes
+func PubMon(a *types.Action) error {
 	return mon.Publish(a, a.StorageKey)
 }
 
 // Subscribes a client to local key change events, which are events that
-// originated on this server.
-func SubscribeLocal(cl stypes.Client) error {
-	return local.Subscribe(cl, single)
+// originated on this server. f may be nil to receive every event.
+func SubscribeLocal(cl stypes.Client, f *pubsub.Filter) error {
+	return local.SubscribeFiltered(cl, f, single)
 }
 
 // Unsubscribes a client from receiving local key change events, if it was
@@ -48,18 +76,33 @@ func UnsubscribeLocal(cl stypes.Client) error {
 	return local.Unsubscribe(cl, single)
 }
 
-// Publishes a key change to those subscribed to local key change events
+// Returns whether or not a client is subscribed to local key change events
+func IsSubscribedLocal(cl stypes.Client) bool {
+	return local.IsSubscribed(cl, single)
+}
+
+// Returns the number of clients subscribed to local key change events
+func LocalCount() int {
+	return local.SubscriberCount(single)
+}
+
+// Publishes a key change to those subscribed to local key change events. The
+// action is tagged with this node's id and a new event id before being
+// published, so it can be tracked as it moves around the cluster
 func PubLocal(a *types.Action) error {
+	a.Origin = stypes.NodeId
+	a.EventId = atomic.AddUint64(&eventCounter, 1)
 	return local.Publish(a, single)
 }
 
-// Subscribes a client to receive keychange events about a particular key
-func Mon(cl stypes.Client, keys ...string) error {
+// Subscribes a client to receive keychange events about a particular key. f
+// may be nil to receive every event on the keys.
+func Mon(cl stypes.Client, f *pubsub.Filter, keys ...string) error {
 	keysStr := make([]string, len(keys))
 	for i := range keys {
 		keysStr[i] = keys[i]
 	}
-	return mon.Subscribe(cl, keysStr...)
+	return mon.SubscribeFiltered(cl, f, keysStr...)
 }
 
 // Unsubscribes a client from particular keys, if it was subscribed at all
@@ -71,6 +114,18 @@ func Unmon(cl stypes.Client, keys ...string) error {
 	return mon.Unsubscribe(cl, keysStr...)
 }
 
+// Returns the keys a client is currently subscribed to receive keychange events
+// about
+func Mons(cl stypes.Client) ([]string, error) {
+	return mon.GetSubscriptions(cl)
+}
+
+// Returns a mapping of every key being monitored to the number of clients
+// monitoring it
+func MonCounts() map[string]int {
+	return mon.SubscriberCounts()
+}
+
 // Unsubscribes a client from any key change events it might be receiving
 func UnsubscribeAll(cl stypes.Client) error {
 	if err := global.Unsubscribe(cl, single); err != nil {
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"fmt"
+	"github.com/grooveshark/golib/gslog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/config"
+	"github.com/mediocregopher/hyrax/server/core/keychanges"
+	"github.com/mediocregopher/hyrax/server/storage/redis"
+	"github.com/mediocregopher/hyrax/types"
+)
+
+// How long after hyrax itself writes to a key that change notifications from
+// the datastore for that key are assumed to be about that write, and dropped
+const notifyDedupWindow = 500 * time.Millisecond
+
+// Notifications for these events are never the result of a write hyrax made,
+// so they are never dropped
+var notifyNeverDedup = map[string]bool{
+	"expired": true,
+	"evicted": true,
+}
+
+// Whether or not storage notifications are being republished. writes is only
+// touched if they are
+var notifyOn bool
+
+// A mapping of keys to the time hyrax last sent a modifying command for them to
+// the datastore
+var writes = map[string]time.Time{}
+var writesLock sync.Mutex
+
+// SetupStorageNotify starts republishing change notifications from the
+// datastore as key change events, if the configuration calls for it
+func SetupStorageNotify() error {
+	var pub func(*types.Action) error
+	switch strings.ToLower(config.StorageNotify) {
+	case "":
+		return nil
+	case "local":
+		pub = keychanges.PubLocal
+	case "global":
+		pub = keychanges.PubGlobal
+	default:
+		return fmt.Errorf("unknown storage-notify: %s", config.StorageNotify)
+	}
+
+	if config.StorageType != "redis" {
+		return fmt.Errorf(
+			"storage-notify is not supported by storage-type %s",
+			config.StorageType,
+		)
+	}
+
+	if len(config.StorageSentinels) > 0 {
+		return fmt.Errorf("storage-notify is not supported with storage-sentinel")
+	}
+
+	gslog.Infof("Republishing datastore notifications as %s key changes",
+		config.StorageNotify)
+	ns := make([]*redis.Notifier, 0, len(config.StorageShards))
+	for _, addr := range config.StorageShards {
+		n, err := redis.NewNotifier("tcp", addr)
+		if err != nil {
+			for _, n := range ns {
+				n.Close()
+			}
+			return err
+		}
+		ns = append(ns, n)
+	}
+
+	notifyOn = true
+	for _, n := range ns {
+		go notifySpin(n, pub)
+	}
+	return nil
+}
+
+func notifySpin(n *redis.Notifier, pub func(*types.Action) error) {
+	sweep := time.NewTicker(10 * notifyDedupWindow)
+	defer sweep.Stop()
+	for {
+		select {
+		case a, ok := <-n.EventCh:
+			if !ok {
+				return
+			}
+			if !notifyNeverDedup[a.Command] && wroteRecently(a.StorageKey) {
+				gslog.Debugf("Dropping datastore notification %v", a)
+				continue
+			}
+			if err := pub(a); err != nil {
+				gslog.Errorf("publishing datastore notification %v: %s", a, err)
+			}
+		case <-sweep.C:
+			sweepWrites()
+		}
+	}
+}
+
+// markWrite records that hyrax is about to send a modifying command for the
+// given key to the datastore. It must be called before the command is sent,
+// since the notification for it may come in before the command returns.
+func markWrite(key string) {
+	if !notifyOn {
+		return
+	}
+	writesLock.Lock()
+	writes[key] = time.Now()
+	writesLock.Unlock()
+}
+
+func wroteRecently(key string) bool {
+	writesLock.Lock()
+	defer writesLock.Unlock()
+	t, ok := writes[key]
+	return ok && time.Since(t) < notifyDedupWindow
+}
+
+func sweepWrites() {
+	writesLock.Lock()
+	defer writesLock.Unlock()
+	for key, t := range writes {
+		if time.Since(t) >= notifyDedupWindow {
+			delete(writes, key)
+		}
+	}
+}
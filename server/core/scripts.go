@@ -0,0 +1,220 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/grooveshark/golib/gslog"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/mediocregopher/hyrax/server/auth"
+	"github.com/mediocregopher/hyrax/server/config"
+	"github.com/mediocregopher/hyrax/server/core/builtin"
+	"github.com/mediocregopher/hyrax/server/core/keychanges"
+	stypes "github.com/mediocregopher/hyrax/server/types"
+	"github.com/mediocregopher/hyrax/types"
+)
+
+// A lua script which has been registered in the configuration, and which
+// clients can call by name
+type script struct {
+	name     string
+	src      string
+	sha      string
+	numKeys  int
+	modifies bool
+}
+
+// All registered scripts, by name. Only changed by SetupScripts
+var scripts = map[string]*script{}
+
+var notEnoughScriptKeys = errors.New("not enough keys given for script")
+var scriptShards = errors.New("script keys live on different shards")
+var wrongScriptSecrets = errors.New("wrong number of secrets given for script")
+var authFailed = errors.New("auth failed")
+
+// SetupScripts loads the scripts given in the configuration. Each is of the
+// form "<name>::<file>::<number of keys>", optionally followed by "::modifies".
+// This must be called after SetupStorage
+func SetupScripts() error {
+	m := map[string]*script{}
+	for _, raw := range config.Scripts {
+		s, err := parseScript(raw)
+		if err != nil {
+			return err
+		}
+		if _, ok := m[s.name]; ok {
+			return fmt.Errorf("script %s given more than once", s.name)
+		}
+		m[s.name] = s
+	}
+
+	if len(m) > 0 && config.StorageType != "redis" {
+		return fmt.Errorf(
+			"scripts are not supported by storage-type %s", config.StorageType,
+		)
+	}
+	for name := range m {
+		gslog.Infof("Loaded script %s", name)
+	}
+	scripts = m
+	return nil
+}
+
+func parseScript(raw string) (*script, error) {
+	parts := strings.Split(raw, "::")
+	if len(parts) < 3 || len(parts) > 4 {
+		return nil, fmt.Errorf("malformed script: %s", raw)
+	}
+
+	name := strings.ToLower(parts[0])
+	if name == "" {
+		return nil, fmt.Errorf("malformed script: %s", raw)
+	} else if builtin.CommandIsBuiltIn(name) || storageUnit.CommandAllowed(name) {
+		return nil, fmt.Errorf("script %s has the same name as a command", name)
+	}
+
+	numKeys, err := strconv.Atoi(parts[2])
+	if err != nil || numKeys < 1 {
+		return nil, fmt.Errorf("script %s must take at least 1 key", name)
+	}
+
+	var modifies bool
+	if len(parts) == 4 {
+		if strings.ToLower(parts[3]) != "modifies" {
+			return nil, fmt.Errorf("unknown script flag: %s", parts[3])
+		}
+		modifies = true
+	}
+
+	src, err := ioutil.ReadFile(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum(src)
+
+	return &script{
+		name:     name,
+		src:      string(src),
+		sha:      hex.EncodeToString(sum[:]),
+		numKeys:  numKeys,
+		modifies: modifies,
+	}, nil
+}
+
+func getScript(cmd string) (*script, bool) {
+	s, ok := scripts[strings.ToLower(cmd)]
+	return s, ok
+}
+
+// keys returns the keys the script is being called on by the action. The first
+// is the action's key, the rest are taken from the front of its args. The rest
+// of the args are returned as well
+func (s *script) keys(cmd *types.Action) ([]string, []interface{}, error) {
+	if len(cmd.Args) < s.numKeys-1 {
+		return nil, nil, notEnoughScriptKeys
+	}
+	keys := make([]string, 1, s.numKeys)
+	keys[0] = cmd.StorageKey
+	for _, k := range cmd.Args[:s.numKeys-1] {
+		if b, ok := k.(types.Binary); ok {
+			keys = append(keys, string(b))
+		} else {
+			keys = append(keys, fmt.Sprint(k))
+		}
+	}
+	for _, key := range keys {
+		if builtin.KeyIsReserved(key) {
+			return nil, nil, builtin.ReservedKeyErr
+		}
+	}
+	return keys, cmd.Args[s.numKeys-1:], nil
+}
+
+// auth checks that the action is authorized for every key the script is being
+// called on. The action's secret is a comma separated list with one secret for
+// each key, in the same order as the keys
+func (s *script) auth(cmd *types.Action) error {
+	if !config.UseGlobalAuth && !config.UseKeyAuth {
+		return nil
+	}
+	keys, _, err := s.keys(cmd)
+	if err != nil {
+		return err
+	}
+	secrets := strings.Split(cmd.Secret, ",")
+	if len(secrets) != len(keys) {
+		return wrongScriptSecrets
+	}
+	for i, key := range keys {
+		kcmd := *cmd
+		kcmd.StorageKey = key
+		kcmd.Secret = secrets[i]
+		ok, err := auth.Auth(&kcmd)
+		if err != nil {
+			return err
+		} else if !ok {
+			return authFailed
+		}
+	}
+	return nil
+}
+
+// dispatchScript runs the script the action names, and publishes a key change
+// event for each of its keys if it modifies them
+func dispatchScript(
+	s *script, c stypes.Client, cmd *types.Action) (interface{}, error) {
+
+	keys, args, err := s.keys(cmd)
+	if err != nil {
+		return nil, err
+	}
+	shard := storageUnit.ShardFor(keys[0])
+	for _, key := range keys[1:] {
+		if storageUnit.ShardFor(key) != shard {
+			return nil, scriptShards
+		}
+	}
+
+	evalArgs := make([]interface{}, 0, 2+len(keys)+len(args))
+	evalArgs = append(evalArgs, s.sha, len(keys))
+	for _, key := range keys {
+		evalArgs = append(evalArgs, key)
+	}
+	evalArgs = append(evalArgs, storageArgs(args)...)
+
+	run := storageUnit.ReadCmd
+	if s.modifies {
+		for _, key := range keys {
+			markWrite(key)
+		}
+		run = storageUnit.Cmd
+	} else if cmd.Consistent {
+		run = storageUnit.Cmd
+	}
+
+	r, err := run(keys[0], storageUnit.NewCommand("evalsha", evalArgs...))
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		evalArgs[0] = s.src
+		r, err = run(keys[0], storageUnit.NewCommand("eval", evalArgs...))
+	}
+	if err == nil && cmd.Binary {
+		r = binaryReply(r)
+	}
+	if err != nil || !s.modifies {
+		return r, err
+	}
+
+	for _, key := range keys {
+		kcmd := *cmd
+		kcmd.StorageKey = key
+		kcmd.Args = args
+		if err := keychanges.PubLocal(&kcmd); err != nil {
+			gslog.Errorf("publishing script %s on %s: %s", s.name, key, err)
+		}
+	}
+	return r, nil
+}
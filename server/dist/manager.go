@@ -2,10 +2,12 @@ package dist
 
 import (
 	"github.com/grooveshark/golib/gslog"
+	"sync"
 	"time"
 
 	"github.com/mediocregopher/hyrax/client"
 	"github.com/mediocregopher/hyrax/server/config"
+	"github.com/mediocregopher/hyrax/server/reconnect"
 	"github.com/mediocregopher/hyrax/types"
 )
 
@@ -38,6 +40,29 @@ type Manager struct {
 	closeCh    chan *call
 	closeAllCh chan *call
 	getAllCh   chan chan []*types.ListenEndpoint
+	statsCh    chan chan []*ClientStats
+
+	// Clients whose spin has stopped are sent here so they can be removed
+	doneCh chan *managerClient
+}
+
+// ClientStats describes a single connection being managed
+type ClientStats struct {
+	Endpoint *types.ListenEndpoint
+
+	// One of the reconnect package's state constants
+	State string
+
+	// The last time the manager's command was successfully run over the
+	// connection. Zero if it never has been
+	LastCmd time.Time
+
+	// How many times reconnecting has been attempted since the connection was
+	// first made
+	Reconnects uint64
+
+	// How many push messages have come in over the connection
+	Events uint64
 }
 
 type managerClient struct {
@@ -45,7 +70,14 @@ type managerClient struct {
 	cl      client.Client
 	pushCh  chan *types.Action
 	closeCh chan struct{}
+
+	// Buffered, so that touching never blocks the manager even if the client
+	// is busy reconnecting
 	touchCh chan struct{}
+
+	// Only touched while holding statsLock
+	stats     ClientStats
+	statsLock sync.Mutex
 }
 
 func newMan(cmd string, args ...interface{}) *Manager {
@@ -60,6 +92,8 @@ func newMan(cmd string, args ...interface{}) *Manager {
 		closeCh:    make(chan *call),
 		closeAllCh: make(chan *call),
 		getAllCh:   make(chan chan []*types.ListenEndpoint),
+		statsCh:    make(chan chan []*ClientStats),
+		doneCh:     make(chan *managerClient),
 	}
 }
 
@@ -114,6 +148,13 @@ func (m *Manager) GetAll() []*types.ListenEndpoint {
 	return <-retCh
 }
 
+// Returns the stats for all currently active connections
+func (m *Manager) Stats() []*ClientStats {
+	retCh := make(chan []*ClientStats)
+	m.statsCh <- retCh
+	return <-retCh
+}
+
 func (m *Manager) spin() {
 	for {
 		select {
@@ -128,6 +169,10 @@ func (m *Manager) spin() {
 			c.retCh <- m.closeAll()
 		case retCh := <-m.getAllCh:
 			retCh <- m.getAllClients()
+		case retCh := <-m.statsCh:
+			retCh <- m.getAllStats()
+		case mcl := <-m.doneCh:
+			m.clientDone(mcl)
 		}
 	}
 }
@@ -137,7 +182,10 @@ func (m *Manager) ensureClient(le *types.ListenEndpoint) error {
 	gslog.Debugf("Ensuring %s connection to node %s", m.cmd, leStr)
 
 	if mcl, ok := m.clients[leStr]; ok {
-		mcl.touchCh <- struct{}{}
+		select {
+		case mcl.touchCh <- struct{}{}:
+		default:
+		}
 		return nil
 	}
 
@@ -153,7 +201,8 @@ func (m *Manager) ensureClient(le *types.ListenEndpoint) error {
 		cl:      cl,
 		pushCh:  pushCh,
 		closeCh: make(chan struct{}),
-		touchCh: make(chan struct{}),
+		touchCh: make(chan struct{}, 1),
+		stats:   ClientStats{Endpoint: le, State: reconnect.Connected},
 	}
 	m.clients[leStr] = &mcl
 	go m.clientSpin(&mcl)
@@ -170,6 +219,15 @@ func (m *Manager) closeClient(le *types.ListenEndpoint) error {
 	return nil
 }
 
+// clientDone removes a client whose spin has stopped, unless it's already been
+// removed (or replaced) by a close
+func (m *Manager) clientDone(mcl *managerClient) {
+	leStr := mcl.le.String()
+	if m.clients[leStr] == mcl {
+		delete(m.clients, leStr)
+	}
+}
+
 func (m *Manager) closeAll() error {
 	for leStr, mcl := range m.clients {
 		close(mcl.closeCh)
@@ -191,6 +249,28 @@ func (m *Manager) getAllClients() []*types.ListenEndpoint {
 	return ret
 }
 
+func (m *Manager) getAllStats() []*ClientStats {
+	ret := make([]*ClientStats, 0, len(m.clients))
+	for _, mcl := range m.clients {
+		mcl.statsLock.Lock()
+		stats := mcl.stats
+		mcl.statsLock.Unlock()
+		ret = append(ret, &stats)
+	}
+	return ret
+}
+
+// updateStats calls the given function on the client's stats while holding
+// the lock on them
+func (mcl *managerClient) updateStats(fn func(*ClientStats)) {
+	mcl.statsLock.Lock()
+	fn(&mcl.stats)
+	mcl.statsLock.Unlock()
+}
+
+// clientSpin periodically runs the manager's command over the client and passes
+// on its push messages, until the client is closed, times out or can't be
+// reconnected. Once it stops it tells the manager, which removes the client
 func (m *Manager) clientSpin(mcl *managerClient) {
 	var timeout *time.Timer
 	var timeoutCh <-chan time.Time
@@ -211,13 +291,14 @@ spinloop:
 			if _, err := mcl.cl.Cmd(cmd); err != nil {
 				gslog.Errorf("dist cmd %s: %s", m.cmd, err)
 				mcl.cl.Close()
-				if !mcl.resurrect() {
+				if !m.resurrect(mcl) {
 					break spinloop
 				} else {
 					continue
 				}
 			} else {
 				doCmd = false
+				mcl.updateStats(func(s *ClientStats) { s.LastCmd = time.Now() })
 			}
 		}
 
@@ -226,15 +307,15 @@ spinloop:
 			if !ok {
 				break spinloop
 			}
+			mcl.updateStats(func(s *ClientStats) { s.Events++ })
 			m.PushCh <- a
 		case <-timeoutCh:
 			gslog.Warnf("Closing %s connection to %s", m.cmd, mcl.le)
-			if err := m.CloseClient(mcl.le); err != nil {
-				gslog.Errorf("Closing %s conn to %s: %s", m.cmd, mcl.le, err)
-			}
 			break spinloop
 		case <-mcl.touchCh:
-			timeout.Reset(m.timeout)
+			if timeout != nil {
+				timeout.Reset(m.timeout)
+			}
 		case <-mcl.closeCh:
 			break spinloop
 		case <-ticker.C:
@@ -244,35 +325,48 @@ spinloop:
 
 	mcl.cl.Close()
 	ticker.Stop()
-	if m.period > 0 {
+	if timeout != nil {
 		timeout.Stop()
 	}
+	m.doneCh <- mcl
 }
 
-func (mcl *managerClient) resurrect() bool {
-	clCh := make(chan client.Client)
-
-	go func() {
-		for {
-			time.Sleep(2 * time.Second)
-			gslog.Debug("Going to resurrect new client on %s", mcl.le)
-			cl, err := client.NewClient(mcl.le, mcl.pushCh)
-			if err != nil {
-				gslog.Errorf("Error reconnecting to %s: %s", mcl.le, err)
-				continue
-			}
-			clCh <- cl
-			return
-		}
-	}()
+// resurrect attempts to reconnect the client, backing off between attempts.
+// Returns false if the client was closed while reconnecting, or if the attempts
+// ran out, in which case clientSpin stops and the manager removes the client
+func (m *Manager) resurrect(mcl *managerClient) bool {
+	leStr := mcl.le.String()
+	mcl.updateStats(func(s *ClientStats) { s.State = reconnect.Reconnecting })
+	reconnect.Emit(m.cmd, leStr, reconnect.Reconnecting, 0)
 
+	b := reconnect.New()
 	for {
+		wait, ok := b.Next()
+		if !ok {
+			gslog.Errorf("Giving up on %s connection to %s", m.cmd, mcl.le)
+			mcl.updateStats(func(s *ClientStats) { s.State = reconnect.GivenUp })
+			reconnect.Emit(m.cmd, leStr, reconnect.GivenUp, b.Attempts())
+			return false
+		}
+
 		select {
-		case cl := <-clCh:
-			mcl.cl = cl
-			return true
 		case <-mcl.closeCh:
 			return false
+		case <-time.After(wait):
 		}
+
+		gslog.Debugf("Going to resurrect new client on %s", mcl.le)
+		mcl.updateStats(func(s *ClientStats) { s.Reconnects++ })
+		cl, err := client.NewClient(mcl.le, mcl.pushCh)
+		if err != nil {
+			gslog.Errorf("Error reconnecting to %s: %s", mcl.le, err)
+			reconnect.Emit(m.cmd, leStr, reconnect.Reconnecting, b.Attempts())
+			continue
+		}
+
+		mcl.cl = cl
+		mcl.updateStats(func(s *ClientStats) { s.State = reconnect.Connected })
+		reconnect.Emit(m.cmd, leStr, reconnect.Connected, 0)
+		return true
 	}
 }
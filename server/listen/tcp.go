@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/grooveshark/golib/gslog"
 	"github.com/mediocregopher/manatcp"
+	"sync"
 	"time"
 
 	stypes "github.com/mediocregopher/hyrax/server/types"
@@ -13,11 +14,13 @@ import (
 )
 
 // ActionWrap bundles an action with a channel which can be read from to receive
-// the return from that action
+// the return from that action. If the action is going to block (e.g. blpop)
+// BlockingCh is closed, and the return is waited on for as long as it takes
 type ActionWrap struct {
 	Action         *types.Action
 	Client         stypes.Client
 	ActionReturnCh chan *types.ActionReturn
+	BlockingCh     chan struct{}
 }
 
 // Whenever a client performs an action it will be wrapped and put on this
@@ -49,6 +52,7 @@ func (tl *tcpListener) Connected(
 		id:        cid,
 		trans:     tl.trans,
 		closeCh:   make(chan struct{}),
+		goneCh:    make(chan struct{}),
 	}
 
 	go c.pushProxy()
@@ -67,6 +71,10 @@ type tcpClient struct {
 	id        stypes.ClientId
 	trans     translate.Translator
 	closeCh   chan struct{}
+
+	// Closed by gone, which may be called more than once
+	goneCh   chan struct{}
+	goneOnce sync.Once
 }
 
 func (tc *tcpClient) pushProxy() {
@@ -87,8 +95,23 @@ func (tc *tcpClient) ClosingCh() <-chan struct{} {
 	return tc.closeCh
 }
 
+func (tc *tcpClient) GoneCh() <-chan struct{} {
+	return tc.goneCh
+}
+
+func (tc *tcpClient) gone() {
+	tc.goneOnce.Do(func() { close(tc.goneCh) })
+}
+
+// Read is called by manatcp in its own go-routine, so even while HandleCmd is
+// busy with a blocking command this is where a disconnect is seen first.
+// manatcp only calls Closing once HandleCmd has returned, so the client is
+// marked as gone here to cancel whatever it's waiting on.
 func (tc *tcpClient) Read(buf *bufio.Reader) (interface{}, bool) {
 	b, err := buf.ReadBytes('\n')
+	if err != nil {
+		tc.gone()
+	}
 	return b, err != nil
 }
 
@@ -124,6 +147,7 @@ func (tc *tcpClient) HandleCmd(cmdRaw interface{}) (interface{}, bool, bool) {
 }
 
 func (tc *tcpClient) Closing() {
+	tc.gone()
 	DispatchClosed(tc)
 	// We sleep some seconds just in case anything is still pushing to the
 	// command channel
@@ -133,7 +157,9 @@ func (tc *tcpClient) Closing() {
 }
 
 func DispatchAction(c stypes.Client, a *types.Action) *types.ActionReturn {
-	aw := ActionWrap{a, c, make(chan *types.ActionReturn)}
+	aw := ActionWrap{
+		a, c, make(chan *types.ActionReturn), make(chan struct{}),
+	}
 	select {
 	case ActionWrapCh <- &aw:
 	case <-time.After(5 * time.Second):
@@ -141,12 +167,18 @@ func DispatchAction(c stypes.Client, a *types.Action) *types.ActionReturn {
 		return types.NewActionReturn(errors.New("timeout"))
 	}
 
-	select {
-	case ar := <-aw.ActionReturnCh:
-		return ar
-	case <-time.After(5 * time.Second):
-		gslog.Error("Timedout receiving ActionReturn from ActionReturnCh")
-		return types.NewActionReturn(errors.New("timeout"))
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ar := <-aw.ActionReturnCh:
+			return ar
+		case <-aw.BlockingCh:
+			aw.BlockingCh = nil
+			timeout = nil
+		case <-timeout:
+			gslog.Error("Timedout receiving ActionReturn from ActionReturnCh")
+			return types.NewActionReturn(errors.New("timeout"))
+		}
 	}
 }
 
@@ -2,6 +2,7 @@ package pubsub
 
 import (
 	"github.com/grooveshark/golib/gslog"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,11 +12,79 @@ import (
 
 const PUB_CHUNK_SIZE = 500
 
+// Filter describes which actions a client actually wants pushed to it on a
+// subscription. A nil Filter, or one with no Commands and no Ids, lets
+// everything through except internal events.
+type Filter struct {
+
+	// Commands is the set of (lowercased) command names to let through
+	Commands map[string]bool
+
+	// Ids is the set of action ids to let through
+	Ids map[string]bool
+
+	// Internal is set for subscriptions which are made by hyrax itself (either
+	// within this node or by another node), and lets internal events through
+	Internal bool
+}
+
+// The (lowercased) commands of internal events, which are passed between nodes
+// for hyrax's own use and aren't meant for clients. Only added to during init
+var internalCommands = map[string]bool{}
+
+// AddInternalCommand marks events with the given command as internal, so that
+// they're only pushed to subscriptions whose Filter has Internal set. This
+// should only be called during init
+func AddInternalCommand(cmd string) {
+	internalCommands[strings.ToLower(cmd)] = true
+}
+
+// NewFilter returns a Filter which only lets through actions whose command is
+// one of cmds and whose id is one of ids. Either can be empty, in which case
+// that field isn't filtered on. If both are empty nil is returned.
+func NewFilter(cmds, ids []string) *Filter {
+	if len(cmds) == 0 && len(ids) == 0 {
+		return nil
+	}
+
+	f := Filter{}
+	if len(cmds) > 0 {
+		f.Commands = map[string]bool{}
+		for _, cmd := range cmds {
+			f.Commands[strings.ToLower(cmd)] = true
+		}
+	}
+	if len(ids) > 0 {
+		f.Ids = map[string]bool{}
+		for _, id := range ids {
+			f.Ids[id] = true
+		}
+	}
+	return &f
+}
+
+// Matches returns whether or not the given action should be let through the
+// filter
+func (f *Filter) Matches(a *types.Action) bool {
+	if internalCommands[strings.ToLower(a.Command)] && (f == nil || !f.Internal) {
+		return false
+	} else if f == nil {
+		return true
+	}
+	if f.Commands != nil && !f.Commands[strings.ToLower(a.Command)] {
+		return false
+	}
+	if f.Ids != nil && !f.Ids[a.Id] {
+		return false
+	}
+	return true
+}
+
 // A system wherein clients can subscribe to channels and others can publish to
 // those channels. Each PubSub instance is a totally separate system, they do
 // not overlap in anyway.
 type PubSub struct {
-	subClients map[string]map[stypes.Client]bool
+	subClients map[string]map[stypes.Client]*Filter
 	clientSubs map[stypes.Client]map[string]bool
 	subChs     map[string]chan *types.Action
 	subLock    sync.RWMutex
@@ -24,7 +93,7 @@ type PubSub struct {
 // Returns a new PubSub system
 func New() *PubSub {
 	return &PubSub{
-		subClients: map[string]map[stypes.Client]bool{},
+		subClients: map[string]map[stypes.Client]*Filter{},
 		clientSubs: map[stypes.Client]map[string]bool{},
 		subChs:     map[string]chan *types.Action{},
 	}
@@ -44,14 +113,18 @@ func (ps *PubSub) subSpin(sub string) {
 	for cmd := range subCh {
 		ps.subLock.RLock()
 		if len(clients) < PUB_CHUNK_SIZE {
-			for client := range clients {
-				pubToClient(client, cmd, sub)
+			for client, f := range clients {
+				if f.Matches(cmd) {
+					pubToClient(client, cmd, sub)
+				}
 			}
 		} else {
 			clientCh := make(chan stypes.Client)
 			go chunker(clientCh, cmd, sub)
-			for client := range clients {
-				clientCh <- client
+			for client, f := range clients {
+				if f.Matches(cmd) {
+					clientCh <- client
+				}
 			}
 			close(clientCh)
 		}
@@ -94,15 +167,24 @@ func pubToClient(c stypes.Client, cmd *types.Action, sub string) {
 // Subscribes a client so that they will receive push messages on the given
 // subscriptions
 func (ps *PubSub) Subscribe(cl stypes.Client, subs ...string) error {
+	return ps.SubscribeFiltered(cl, nil, subs...)
+}
+
+// Like Subscribe, but the client will only receive push messages which match
+// the given Filter. Subscribing to a subscription the client is already on
+// replaces the Filter it had for it.
+func (ps *PubSub) SubscribeFiltered(
+	cl stypes.Client, f *Filter, subs ...string) error {
+
 	ps.subLock.Lock()
 	defer ps.subLock.Unlock()
 
 	for _, sub := range subs {
 		sc, ok := ps.subClients[sub]
 		if ok {
-			sc[cl] = true
+			sc[cl] = f
 		} else {
-			ps.subClients[sub] = map[stypes.Client]bool{cl: true}
+			ps.subClients[sub] = map[stypes.Client]*Filter{cl: f}
 			subCh := make(chan *types.Action)
 			ps.subChs[sub] = subCh
 			go ps.subSpin(sub)
@@ -170,6 +252,36 @@ func (ps *PubSub) GetSubscriptions(cl stypes.Client) ([]string, error) {
 	return ret, nil
 }
 
+// Returns whether or not a client is subscribed to the given subscription
+func (ps *PubSub) IsSubscribed(cl stypes.Client, sub string) bool {
+	ps.subLock.RLock()
+	defer ps.subLock.RUnlock()
+
+	_, ok := ps.subClients[sub][cl]
+	return ok
+}
+
+// Returns the number of clients subscribed to the given subscription
+func (ps *PubSub) SubscriberCount(sub string) int {
+	ps.subLock.RLock()
+	defer ps.subLock.RUnlock()
+
+	return len(ps.subClients[sub])
+}
+
+// Returns a mapping of every subscription which has at least one client
+// subscribed to it to the number of clients subscribed to it
+func (ps *PubSub) SubscriberCounts() map[string]int {
+	ps.subLock.RLock()
+	defer ps.subLock.RUnlock()
+
+	ret := make(map[string]int, len(ps.subClients))
+	for sub, sc := range ps.subClients {
+		ret[sub] = len(sc)
+	}
+	return ret
+}
+
 // Unsubscribes a given client from any subscriptions it's subscribed to
 func (ps *PubSub) UnsubscribeAll(cs stypes.Client) error {
 	subs, err := ps.GetSubscriptions(cs)
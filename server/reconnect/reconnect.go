@@ -0,0 +1,144 @@
+// Package reconnect handles the timing of reconnect attempts for connections
+// hyrax makes out to other things (other nodes, the datastore), and lets other
+// code know when those connections change state
+package reconnect
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/config"
+)
+
+// The states a connection can be in
+const (
+	Connected    = "connected"
+	Reconnecting = "reconnecting"
+	GivenUp      = "givenup"
+)
+
+// Every jitter is taken from this, so that nodes which lose a connection at the
+// same time don't end up retrying in lockstep
+var jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+var jitterLock sync.Mutex
+
+// Backoff keeps track of how long to wait between reconnect attempts. The wait
+// starts at Min and doubles with each attempt up to Max, and a random jitter of
+// up to half the wait is taken off of it each time.
+type Backoff struct {
+	Min, Max time.Duration
+
+	// The number of attempts after which to give up, 0 to never give up
+	MaxAttempts int
+
+	attempts int
+}
+
+// New returns a Backoff using the reconnect settings from the configuration
+func New() *Backoff {
+	return &Backoff{
+		Min:         config.ReconnectMin,
+		Max:         config.ReconnectMax,
+		MaxAttempts: config.ReconnectMaxAttempts,
+	}
+}
+
+// NewForever is like New, but the returned Backoff never gives up
+func NewForever() *Backoff {
+	b := New()
+	b.MaxAttempts = 0
+	return b
+}
+
+// Next returns how long to wait before making the next attempt, or false if
+// the attempts have run out
+func (b *Backoff) Next() (time.Duration, bool) {
+	if b.MaxAttempts > 0 && b.attempts >= b.MaxAttempts {
+		return 0, false
+	}
+
+	d := b.Min
+	for i := 0; i < b.attempts && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	b.attempts++
+
+	if half := int64(d / 2); half > 0 {
+		jitterLock.Lock()
+		d -= time.Duration(jitterRand.Int63n(half + 1))
+		jitterLock.Unlock()
+	}
+	return d, true
+}
+
+// Attempts returns the number of times Next has returned a wait since the
+// Backoff was made or last Reset
+func (b *Backoff) Attempts() int {
+	return b.attempts
+}
+
+// Reset puts the Backoff back to how it was when it was made
+func (b *Backoff) Reset() {
+	b.attempts = 0
+}
+
+// Event describes a connection changing state
+type Event struct {
+	// What the connection is for, e.g. "redis" or "MGLOBAL"
+	Kind string
+
+	// The address the connection is to
+	Addr string
+
+	// One of the state constants
+	State string
+
+	// The number of reconnect attempts made so far, if reconnecting
+	Attempt int
+
+	Time time.Time
+}
+
+var watchers = map[chan *Event]bool{}
+var watchersLock sync.RWMutex
+
+// Watch returns a channel which every connection state change will be sent
+// to. If the channel's buffer is full when an event comes in the event is
+// dropped for it, so it should be read from promptly
+func Watch() chan *Event {
+	ch := make(chan *Event, 32)
+	watchersLock.Lock()
+	watchers[ch] = true
+	watchersLock.Unlock()
+	return ch
+}
+
+// Unwatch stops events being sent to a channel returned from Watch
+func Unwatch(ch chan *Event) {
+	watchersLock.Lock()
+	delete(watchers, ch)
+	watchersLock.Unlock()
+}
+
+// Emit sends out an event for a connection changing to the given state
+func Emit(kind, addr, state string, attempt int) {
+	e := &Event{
+		Kind:    kind,
+		Addr:    addr,
+		State:   state,
+		Attempt: attempt,
+		Time:    time.Now(),
+	}
+	watchersLock.RLock()
+	defer watchersLock.RUnlock()
+	for ch := range watchers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
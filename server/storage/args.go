@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/mediocregopher/hyrax/server/config"
+)
+
+var badArgType = errors.New("ERR invalid argument type")
+
+// Status is a status reply from the datastore, like OK or the name of a key's
+// type, as opposed to a value which was stored in it. It's sent to clients as a
+// plain string, but is never turned into Binary
+type Status string
+
+// StatusOK is the status returned by commands which have nothing else to return
+const StatusOK Status = "OK"
+
+// The largest string value, in bytes, and the largest bit offset into one which
+// commands like setrange and setbit may create. These are the same as redis's
+const (
+	MaxStringSize = 512 * 1024 * 1024
+	MaxBitOffset  = 1<<32 - 1
+)
+
+// ArgsToStrs converts the arguments to a command into strings, the same way
+// they would be sent to redis. Useful for backends which only deal in strings
+func ArgsToStrs(args []interface{}) ([]string, error) {
+	strs := make([]string, len(args))
+	for i := range args {
+		switch argt := args[i].(type) {
+		case string:
+			strs[i] = argt
+		case []byte:
+			strs[i] = string(argt)
+		case int:
+			strs[i] = strconv.Itoa(argt)
+		case int64:
+			strs[i] = strconv.FormatInt(argt, 10)
+		case uint64:
+			strs[i] = strconv.FormatUint(argt, 10)
+		case float64:
+			strs[i] = FormatFloat(argt)
+		case bool:
+			if argt {
+				strs[i] = "1"
+			} else {
+				strs[i] = "0"
+			}
+		default:
+			return nil, badArgType
+		}
+	}
+	return strs, nil
+}
+
+// FormatFloat formats a float the same way redis does
+func FormatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// FloatReply returns the float the way it should be replied to clients with.
+// This is as a number if storage-typed-replies is set, otherwise it's formatted
+// into a string the same way redis does. Infinities are always strings, since
+// not every syntax can encode them as numbers
+func FloatReply(f float64) interface{} {
+	if config.StorageTypedReplies && !math.IsInf(f, 0) {
+		return f
+	}
+	return FormatFloat(f)
+}
@@ -0,0 +1,296 @@
+package boltdb
+
+import (
+	"errors"
+	"github.com/boltdb/bolt"
+	"github.com/grooveshark/golib/gslog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/storage"
+)
+
+// A command into the bolt database, implements the Command interface
+type BoltCommand struct {
+	cmd  string
+	args []interface{}
+}
+
+// Returns a new BoltCommand with the given arguments
+func NewBoltCommand(cmd string, args ...interface{}) storage.Command {
+	return &BoltCommand{
+		cmd:  cmd,
+		args: args,
+	}
+}
+
+func (c *BoltCommand) Cmd() string {
+	return c.cmd
+}
+
+func (c *BoltCommand) Args() []interface{} {
+	return c.args
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// The top level buckets in the database. keys maps every key to its type,
+// strings and hashes hold the values of keys of those types (each hash being a
+// bucket of its own), and expires maps keys with an expiry to the unix
+// nanosecond time they expire at
+var (
+	keysBucket    = []byte("keys")
+	stringsBucket = []byte("strings")
+	hashesBucket  = []byte("hashes")
+	expiresBucket = []byte("expires")
+)
+
+var allBuckets = [][]byte{keysBucket, stringsBucket, hashesBucket, expiresBucket}
+
+// How often keys which have expired but haven't been touched are cleaned out
+const sweepPeriod = 1 * time.Second
+
+// A database file which is open, and shared by all connections to it. Bolt
+// only allows a file to be opened once
+type sharedDB struct {
+	*bolt.DB
+	refs    int
+	closeCh chan struct{}
+}
+
+var dbs = map[string]*sharedDB{}
+var dbsLock sync.Mutex
+
+func openDB(path string) (*sharedDB, error) {
+	dbsLock.Lock()
+	defer dbsLock.Unlock()
+	if db, ok := dbs[path]; ok {
+		db.refs++
+		return db, nil
+	}
+
+	bdb, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		for _, name := range allBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		bdb.Close()
+		return nil, err
+	}
+
+	db := &sharedDB{DB: bdb, refs: 1, closeCh: make(chan struct{})}
+	dbs[path] = db
+	go db.sweepSpin()
+	return db, nil
+}
+
+func closeDB(path string) error {
+	dbsLock.Lock()
+	defer dbsLock.Unlock()
+	db, ok := dbs[path]
+	if !ok {
+		return nil
+	}
+	if db.refs--; db.refs > 0 {
+		return nil
+	}
+	delete(dbs, path)
+	close(db.closeCh)
+	return db.Close()
+}
+
+func (db *sharedDB) sweepSpin() {
+	tick := time.NewTicker(sweepPeriod)
+	defer tick.Stop()
+	for {
+		select {
+		case <-db.closeCh:
+			return
+		case <-tick.C:
+		}
+		err := db.Update(func(tx *bolt.Tx) error {
+			t := &txn{tx: tx, now: time.Now(), writable: true}
+			var expired []string
+			tx.Bucket(expiresBucket).ForEach(func(k, v []byte) error {
+				if t.isExpired(v) {
+					expired = append(expired, string(k))
+				}
+				return nil
+			})
+			for _, key := range expired {
+				if err := t.delKey(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			gslog.Errorf("sweeping expired keys: %s", err)
+		}
+	}
+}
+
+// A connection to a bolt database, implements the Storage interface
+type BoltConn struct {
+	path    string
+	db      *sharedDB
+	cmdCh   chan *storage.CommandBundle
+	closeCh chan chan error
+}
+
+// Returns an unconnected bolt connection structure as per the Storage
+// interface
+func New() storage.Storage {
+	return &BoltConn{}
+}
+
+// Implements Connect for Storage. The address is the path to the database
+// file, which is created if it doesn't exist
+func (b *BoltConn) Connect(cmdCh chan *storage.CommandBundle,
+	_, path string, _ ...interface{}) error {
+
+	db, err := openDB(path)
+	if err != nil {
+		gslog.Errorf("opening bolt database at %s: %s", path, err)
+		return err
+	}
+
+	b.path = path
+	b.db = db
+	b.cmdCh = cmdCh
+	b.closeCh = make(chan chan error)
+	go b.spin()
+	return nil
+}
+
+func (b *BoltConn) spin() {
+	for {
+		select {
+		case retCh := <-b.closeCh:
+			retCh <- closeDB(b.path)
+			return
+
+		case cmdb := <-b.cmdCh:
+			rawret, err := b.cmd(cmdb.Cmd)
+			ret := storage.CommandRet{Ret: rawret, Err: err}
+			select {
+			case cmdb.RetCh <- &ret:
+			case <-time.After(10 * time.Second):
+				gslog.Errorf("BoltConn timedout replying to cmd %v", cmdb.Cmd)
+			}
+		}
+	}
+}
+
+// Every command is run in its own transaction. Commands which modify anything
+// are run in a read-write transaction, which bolt syncs to disk before it
+// returns, so a command which has returned is never lost in a crash. A command
+// which returns an error has all its changes rolled back.
+func (b *BoltConn) cmd(cmd storage.Command) (interface{}, error) {
+	gslog.Debugf("Bolt cmd: %v, %v", cmd.Cmd(), cmd.Args())
+
+	name := strings.ToLower(cmd.Cmd())
+	if name == "eval" {
+		return b.eval(cmd.Args())
+	}
+
+	args, err := storage.ArgsToStrs(cmd.Args())
+	if err != nil {
+		return nil, err
+	}
+
+	var ret interface{}
+	fn := func(tx *bolt.Tx) error {
+		t := &txn{tx: tx, now: time.Now(), writable: tx.Writable()}
+		ret, err = t.call(name, args)
+		return err
+	}
+	if cinfo, ok := commandMap[name]; ok && cinfo.Modifies {
+		err = b.db.Update(fn)
+	} else {
+		err = b.db.View(fn)
+	}
+	return ret, err
+}
+
+// eval runs the go implementation of a script, if one has been registered,
+// within a single transaction
+func (b *BoltConn) eval(rawArgs []interface{}) (interface{}, error) {
+	if len(rawArgs) < 2 {
+		return nil, errors.New("ERR wrong number of arguments for 'eval' command")
+	}
+	args, err := storage.ArgsToStrs(rawArgs)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := storage.GetScriptFunc(args[0])
+	if !ok {
+		return nil, errors.New("ERR scripts are not supported by this backend")
+	}
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || numKeys < 0 || numKeys > len(args)-2 {
+		return nil, errors.New("ERR invalid number of keys")
+	}
+	keys := args[2 : 2+numKeys]
+	sargs := make([]interface{}, 0, len(args)-2-numKeys)
+	for _, arg := range args[2+numKeys:] {
+		sargs = append(sargs, arg)
+	}
+
+	var ret interface{}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		t := &txn{tx: tx, now: time.Now(), writable: true}
+		call := func(cmd string, cargs ...interface{}) (interface{}, error) {
+			strs, err := storage.ArgsToStrs(cargs)
+			if err != nil {
+				return nil, err
+			}
+			return t.call(strings.ToLower(cmd), strs)
+		}
+		var err error
+		ret, err = fn(call, keys, sargs)
+		return err
+	})
+	return ret, err
+}
+
+// Implements NewCommand for Storage
+func (_ *BoltConn) NewCommand(cmd string, args ...interface{}) storage.Command {
+	return NewBoltCommand(cmd, args...)
+}
+
+// Implements CommandAllowed for Storage
+func (_ *BoltConn) CommandAllowed(cmd string) bool {
+	_, ok := getCommandInfo(cmd)
+	return ok
+}
+
+// Implements CommandModifies for Storage
+func (_ *BoltConn) CommandModifies(cmd string) bool {
+	cinfo, ok := getCommandInfo(cmd)
+	return ok && cinfo.Modifies
+}
+
+// Implements CommandIsAdmin for Storage. There are no administrative commands
+// so this is always false
+func (_ *BoltConn) CommandIsAdmin(_ string) bool {
+	return false
+}
+
+// Implements Close for Storage. The database file is closed once every
+// connection to it has been
+func (b *BoltConn) Close() error {
+	retCh := make(chan error)
+	b.closeCh <- retCh
+	return <-retCh
+}
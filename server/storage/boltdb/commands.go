@@ -0,0 +1,766 @@
+package boltdb
+
+import (
+	"errors"
+	"github.com/boltdb/bolt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/storage"
+)
+
+// A transaction a command is being run in
+type txn struct {
+	tx       *bolt.Tx
+	now      time.Time
+	writable bool
+}
+
+// A function implementing a single command. args includes the key as its first
+// element
+type cmdFunc func(t *txn, args []string) (interface{}, error)
+
+// CommandInfo is a struct which is tied to a command, and describes various
+// properties of the command. All properties are false by default.
+type CommandInfo struct {
+	Modifies bool
+
+	// Internal commands are only used by hyrax itself (e.g. from scripts), and
+	// can't be called by clients
+	Internal bool
+
+	// The number of arguments the command takes, including the key. If
+	// negative it's the minimum number
+	Arity int
+
+	fn cmdFunc
+}
+
+// commandMap is a map of commands to their info structs. Only the key, string
+// and hash commands of the redis backend are supported
+var commandMap = map[string]*CommandInfo{
+
+	//Keys
+	"del":       {Modifies: true, Internal: true, Arity: -1, fn: del},
+	"exists":    {Arity: 1, fn: exists},
+	"expire":    {Modifies: true, Arity: 2, fn: expire},
+	"expireat":  {Modifies: true, Arity: 2, fn: expireat},
+	"persist":   {Modifies: true, Arity: 1, fn: persist},
+	"pexpire":   {Modifies: true, Arity: 2, fn: pexpire},
+	"pexpireat": {Modifies: true, Arity: 2, fn: pexpireat},
+	"pttl":      {Arity: 1, fn: pttl},
+	"ttl":       {Arity: 1, fn: ttl},
+	"type":      {Arity: 1, fn: typeCmd},
+
+	//Strings
+	"append":      {Modifies: true, Arity: 2, fn: appendCmd},
+	"decr":        {Modifies: true, Arity: 1, fn: decr},
+	"decrby":      {Modifies: true, Arity: 2, fn: decrby},
+	"get":         {Arity: 1, fn: get},
+	"getrange":    {Arity: 3, fn: getrange},
+	"getset":      {Modifies: true, Arity: 2, fn: getset},
+	"incr":        {Modifies: true, Arity: 1, fn: incr},
+	"incrby":      {Modifies: true, Arity: 2, fn: incrby},
+	"incrbyfloat": {Modifies: true, Arity: 2, fn: incrbyfloat},
+	"psetex":      {Modifies: true, Arity: 3, fn: psetex},
+	"set":         {Modifies: true, Arity: -2, fn: set},
+	"setex":       {Modifies: true, Arity: 3, fn: setex},
+	"setnx":       {Modifies: true, Arity: 2, fn: setnx},
+	"setrange":    {Modifies: true, Arity: 3, fn: setrange},
+	"strlen":      {Arity: 1, fn: strlen},
+
+	//Hashes
+	"hdel":         {Modifies: true, Arity: -2, fn: hdel},
+	"hexists":      {Arity: 2, fn: hexists},
+	"hget":         {Arity: 2, fn: hget},
+	"hgetall":      {Arity: 1, fn: hgetall},
+	"hincrby":      {Modifies: true, Arity: 3, fn: hincrby},
+	"hincrbyfloat": {Modifies: true, Arity: 3, fn: hincrbyfloat},
+	"hkeys":        {Arity: 1, fn: hkeys},
+	"hlen":         {Arity: 1, fn: hlen},
+	"hmget":        {Arity: -2, fn: hmget},
+	"hset":         {Modifies: true, Arity: 3, fn: hset},
+	"hsetnx":       {Modifies: true, Arity: 3, fn: hsetnx},
+	"hvals":        {Arity: 1, fn: hvals},
+}
+
+func getCommandInfo(cmd string) (*CommandInfo, bool) {
+	cinfo, ok := commandMap[strings.ToLower(cmd)]
+	if ok && cinfo.Internal {
+		return nil, false
+	}
+	return cinfo, ok
+}
+
+// call runs the command in the transaction. Internal commands are allowed
+func (t *txn) call(name string, args []string) (interface{}, error) {
+	cinfo, ok := commandMap[name]
+	if !ok {
+		return nil, errors.New("ERR unknown command '" + name + "'")
+	}
+	if (cinfo.Arity >= 0 && len(args) != cinfo.Arity) ||
+		(cinfo.Arity < 0 && len(args) < -cinfo.Arity) {
+		return nil, errors.New(
+			"ERR wrong number of arguments for '" + name + "' command",
+		)
+	}
+	if cinfo.Modifies && !t.writable {
+		return nil, errors.New("ERR command modifies but transaction is read-only")
+	}
+	return cinfo.fn(t, args)
+}
+
+// Errors returned by commands, worded the same as redis's
+var (
+	errWrongType  = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInt     = errors.New("ERR value is not an integer or out of range")
+	errNotFloat   = errors.New("ERR value is not a valid float")
+	errSyntax     = errors.New("ERR syntax error")
+	errExpireTime = errors.New("ERR invalid expire time")
+	errOffset     = errors.New("ERR offset is out of range")
+)
+
+// The types keys can have, as stored in the keys bucket
+const (
+	typeString = "string"
+	typeHash   = "hash"
+)
+
+func parseInt(s string) (int64, error) {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errNotInt
+	}
+	return i, nil
+}
+
+func parseFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errNotFloat
+	}
+	return f, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Keys
+
+func (t *txn) isExpired(expiresB []byte) bool {
+	ns, err := strconv.ParseInt(string(expiresB), 10, 64)
+	return err == nil && !t.now.Before(time.Unix(0, ns))
+}
+
+// keyType returns the type of the key, or empty string if it doesn't exist.
+// A key which has expired doesn't exist, and is deleted if the transaction is
+// writable
+func (t *txn) keyType(key string) string {
+	typ := t.tx.Bucket(keysBucket).Get([]byte(key))
+	if typ == nil {
+		return ""
+	}
+	if exp := t.tx.Bucket(expiresBucket).Get([]byte(key)); exp != nil &&
+		t.isExpired(exp) {
+		if t.writable {
+			t.delKey(key)
+		}
+		return ""
+	}
+	return string(typ)
+}
+
+// delKey removes the key and its value, whatever its type
+func (t *txn) delKey(key string) error {
+	keyB := []byte(key)
+	switch string(t.tx.Bucket(keysBucket).Get(keyB)) {
+	case typeString:
+		if err := t.tx.Bucket(stringsBucket).Delete(keyB); err != nil {
+			return err
+		}
+	case typeHash:
+		err := t.tx.Bucket(hashesBucket).DeleteBucket(keyB)
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+	}
+	if err := t.tx.Bucket(expiresBucket).Delete(keyB); err != nil {
+		return err
+	}
+	return t.tx.Bucket(keysBucket).Delete(keyB)
+}
+
+func (t *txn) expiry(key string) (time.Time, bool) {
+	exp := t.tx.Bucket(expiresBucket).Get([]byte(key))
+	if exp == nil {
+		return time.Time{}, false
+	}
+	ns, err := strconv.ParseInt(string(exp), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns), true
+}
+
+func (t *txn) setExpiry(key string, at time.Time) error {
+	ns := strconv.FormatInt(at.UnixNano(), 10)
+	return t.tx.Bucket(expiresBucket).Put([]byte(key), []byte(ns))
+}
+
+func del(t *txn, args []string) (interface{}, error) {
+	n := 0
+	for _, key := range args {
+		if t.keyType(key) == "" {
+			continue
+		}
+		if err := t.delKey(key); err != nil {
+			return nil, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func exists(t *txn, args []string) (interface{}, error) {
+	if t.keyType(args[0]) == "" {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func expireFn(unit time.Duration, absolute bool) cmdFunc {
+	return func(t *txn, args []string) (interface{}, error) {
+		i, err := parseInt(args[1])
+		if err != nil {
+			return nil, err
+		}
+		var at time.Time
+		if absolute {
+			at = time.Unix(0, 0).Add(time.Duration(i) * unit)
+		} else {
+			at = t.now.Add(time.Duration(i) * unit)
+		}
+
+		if t.keyType(args[0]) == "" {
+			return 0, nil
+		} else if !at.After(t.now) {
+			return 1, t.delKey(args[0])
+		}
+		return 1, t.setExpiry(args[0], at)
+	}
+}
+
+var (
+	expire    = expireFn(time.Second, false)
+	expireat  = expireFn(time.Second, true)
+	pexpire   = expireFn(time.Millisecond, false)
+	pexpireat = expireFn(time.Millisecond, true)
+)
+
+func persist(t *txn, args []string) (interface{}, error) {
+	if t.keyType(args[0]) == "" {
+		return 0, nil
+	} else if _, ok := t.expiry(args[0]); !ok {
+		return 0, nil
+	}
+	return 1, t.tx.Bucket(expiresBucket).Delete([]byte(args[0]))
+}
+
+func ttlFn(unit time.Duration) cmdFunc {
+	return func(t *txn, args []string) (interface{}, error) {
+		if t.keyType(args[0]) == "" {
+			return -2, nil
+		}
+		at, ok := t.expiry(args[0])
+		if !ok {
+			return -1, nil
+		}
+		left := at.Sub(t.now)
+		return int((left + unit/2) / unit), nil
+	}
+}
+
+var (
+	ttl  = ttlFn(time.Second)
+	pttl = ttlFn(time.Millisecond)
+)
+
+func typeCmd(t *txn, args []string) (interface{}, error) {
+	if typ := t.keyType(args[0]); typ != "" {
+		return storage.Status(typ), nil
+	}
+	return storage.Status("none"), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Strings
+
+// getStr returns the string value of the key, or false if the key doesn't
+// exist
+func (t *txn) getStr(key string) (string, bool, error) {
+	switch t.keyType(key) {
+	case "":
+		return "", false, nil
+	case typeString:
+		return string(t.tx.Bucket(stringsBucket).Get([]byte(key))), true, nil
+	}
+	return "", false, errWrongType
+}
+
+// setStr sets the string value of the key, replacing whatever was there. If
+// keepTTL is set any expiry on the key is kept, otherwise it's cleared
+func (t *txn) setStr(key, val string, keepTTL bool) error {
+	keyB := []byte(key)
+	if typ := t.keyType(key); typ != "" && typ != typeString {
+		if err := t.delKey(key); err != nil {
+			return err
+		}
+	} else if !keepTTL {
+		if err := t.tx.Bucket(expiresBucket).Delete(keyB); err != nil {
+			return err
+		}
+	}
+	if err := t.tx.Bucket(keysBucket).Put(keyB, []byte(typeString)); err != nil {
+		return err
+	}
+	return t.tx.Bucket(stringsBucket).Put(keyB, []byte(val))
+}
+
+func get(t *txn, args []string) (interface{}, error) {
+	str, ok, err := t.getStr(args[0])
+	if err != nil || !ok {
+		return nil, err
+	}
+	return str, nil
+}
+
+func set(t *txn, args []string) (interface{}, error) {
+	var expires time.Time
+	var nx, xx bool
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "NX":
+			nx = true
+		case opt == "XX":
+			xx = true
+		case (opt == "EX" || opt == "PX") && i+1 < len(args):
+			n, err := parseInt(args[i+1])
+			if err != nil {
+				return nil, err
+			} else if n <= 0 {
+				return nil, errExpireTime
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			expires = t.now.Add(time.Duration(n) * unit)
+			i++
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	exists := t.keyType(args[0]) != ""
+	if (nx && exists) || (xx && !exists) {
+		return nil, nil
+	}
+	if err := t.setStr(args[0], args[1], false); err != nil {
+		return nil, err
+	}
+	if !expires.IsZero() {
+		if err := t.setExpiry(args[0], expires); err != nil {
+			return nil, err
+		}
+	}
+	return storage.StatusOK, nil
+}
+
+func setnx(t *txn, args []string) (interface{}, error) {
+	if t.keyType(args[0]) != "" {
+		return 0, nil
+	}
+	return 1, t.setStr(args[0], args[1], false)
+}
+
+func setexFn(unit time.Duration) cmdFunc {
+	return func(t *txn, args []string) (interface{}, error) {
+		n, err := parseInt(args[1])
+		if err != nil {
+			return nil, err
+		} else if n <= 0 {
+			return nil, errExpireTime
+		}
+		if err := t.setStr(args[0], args[2], false); err != nil {
+			return nil, err
+		}
+		at := t.now.Add(time.Duration(n) * unit)
+		return storage.StatusOK, t.setExpiry(args[0], at)
+	}
+}
+
+var (
+	setex  = setexFn(time.Second)
+	psetex = setexFn(time.Millisecond)
+)
+
+func getset(t *txn, args []string) (interface{}, error) {
+	old, ok, err := t.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := t.setStr(args[0], args[1], false); err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+	return old, nil
+}
+
+func appendCmd(t *txn, args []string) (interface{}, error) {
+	str, _, err := t.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+	str += args[1]
+	return len(str), t.setStr(args[0], str, true)
+}
+
+func strlen(t *txn, args []string) (interface{}, error) {
+	str, _, err := t.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return len(str), nil
+}
+
+// incrBy adds delta to the integer value of the key, treating a missing key as
+// 0, and returns the new value
+func (t *txn) incrBy(key string, delta int64) (interface{}, error) {
+	str, ok, err := t.getStr(key)
+	if err != nil {
+		return nil, err
+	}
+	var i int64
+	if ok {
+		if i, err = parseInt(str); err != nil {
+			return nil, err
+		}
+	}
+	i += delta
+	return int(i), t.setStr(key, strconv.FormatInt(i, 10), true)
+}
+
+func incr(t *txn, args []string) (interface{}, error) {
+	return t.incrBy(args[0], 1)
+}
+
+func decr(t *txn, args []string) (interface{}, error) {
+	return t.incrBy(args[0], -1)
+}
+
+func incrby(t *txn, args []string) (interface{}, error) {
+	delta, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return t.incrBy(args[0], delta)
+}
+
+func decrby(t *txn, args []string) (interface{}, error) {
+	delta, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return t.incrBy(args[0], -delta)
+}
+
+func incrbyfloat(t *txn, args []string) (interface{}, error) {
+	delta, err := parseFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	str, ok, err := t.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+	var f float64
+	if ok {
+		if f, err = parseFloat(str); err != nil {
+			return nil, err
+		}
+	}
+	newStr := storage.FormatFloat(f + delta)
+	return storage.FloatReply(f + delta), t.setStr(args[0], newStr, true)
+}
+
+func getrange(t *txn, args []string) (interface{}, error) {
+	str, _, err := t.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+	start, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	stop, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	n := int64(len(str))
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return "", nil
+	}
+	return str[start : stop+1], nil
+}
+
+func setrange(t *txn, args []string) (interface{}, error) {
+	offset, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	} else if offset < 0 || offset > storage.MaxStringSize-int64(len(args[2])) {
+		return nil, errOffset
+	}
+	str, _, err := t.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if args[2] == "" {
+		return len(str), nil
+	}
+
+	b := []byte(str)
+	if end := int(offset) + len(args[2]); end > len(b) {
+		b = append(b, make([]byte, end-len(b))...)
+	}
+	copy(b[offset:], args[2])
+	return len(b), t.setStr(args[0], string(b), true)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Hashes
+
+// getHash returns the bucket holding the hash at the key. If the key doesn't
+// exist and create is set a new empty hash is made for it, otherwise nil is
+// returned
+func (t *txn) getHash(key string, create bool) (*bolt.Bucket, error) {
+	keyB := []byte(key)
+	switch t.keyType(key) {
+	case typeHash:
+		return t.tx.Bucket(hashesBucket).Bucket(keyB), nil
+	case "":
+		if !create {
+			return nil, nil
+		}
+		err := t.tx.Bucket(keysBucket).Put(keyB, []byte(typeHash))
+		if err != nil {
+			return nil, err
+		}
+		return t.tx.Bucket(hashesBucket).CreateBucketIfNotExists(keyB)
+	}
+	return nil, errWrongType
+}
+
+// cleanHash removes the hash at the key if it's become empty
+func (t *txn) cleanHash(key string, h *bolt.Bucket) error {
+	if h == nil {
+		return nil
+	}
+	if k, _ := h.Cursor().First(); k != nil {
+		return nil
+	}
+	return t.delKey(key)
+}
+
+// hashGet returns the value of the field in the hash, or false if it's not set
+func hashGet(h *bolt.Bucket, field string) (string, bool) {
+	if h == nil {
+		return "", false
+	}
+	v := h.Get([]byte(field))
+	if v == nil {
+		return "", false
+	}
+	return string(v), true
+}
+
+// hashEach calls fn on every field and value in the hash, in sorted order
+func hashEach(h *bolt.Bucket, fn func(field, val string)) {
+	if h == nil {
+		return
+	}
+	h.ForEach(func(k, v []byte) error {
+		fn(string(k), string(v))
+		return nil
+	})
+}
+
+func hsetFn(nx bool) cmdFunc {
+	return func(t *txn, args []string) (interface{}, error) {
+		h, err := t.getHash(args[0], true)
+		if err != nil {
+			return nil, err
+		}
+		_, exists := hashGet(h, args[1])
+		if exists && nx {
+			return 0, nil
+		}
+		if err := h.Put([]byte(args[1]), []byte(args[2])); err != nil {
+			return nil, err
+		} else if exists {
+			return 0, nil
+		}
+		return 1, nil
+	}
+}
+
+var (
+	hset   = hsetFn(false)
+	hsetnx = hsetFn(true)
+)
+
+func hget(t *txn, args []string) (interface{}, error) {
+	h, err := t.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := hashGet(h, args[1]); ok {
+		return v, nil
+	}
+	return nil, nil
+}
+
+func hmget(t *txn, args []string) (interface{}, error) {
+	h, err := t.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(args)-1)
+	for i, f := range args[1:] {
+		if v, ok := hashGet(h, f); ok {
+			vals[i] = v
+		}
+	}
+	return vals, nil
+}
+
+func hdel(t *txn, args []string) (interface{}, error) {
+	h, err := t.getHash(args[0], false)
+	if err != nil || h == nil {
+		return 0, err
+	}
+	n := 0
+	for _, f := range args[1:] {
+		if _, ok := hashGet(h, f); !ok {
+			continue
+		}
+		if err := h.Delete([]byte(f)); err != nil {
+			return nil, err
+		}
+		n++
+	}
+	return n, t.cleanHash(args[0], h)
+}
+
+func hexists(t *txn, args []string) (interface{}, error) {
+	h, err := t.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := hashGet(h, args[1]); ok {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func hlen(t *txn, args []string) (interface{}, error) {
+	h, err := t.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	hashEach(h, func(_, _ string) { n++ })
+	return n, nil
+}
+
+func hgetall(t *txn, args []string) (interface{}, error) {
+	h, err := t.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]string{}
+	hashEach(h, func(f, v string) { ret[f] = v })
+	return ret, nil
+}
+
+func hkeys(t *txn, args []string) (interface{}, error) {
+	h, err := t.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	hashEach(h, func(f, _ string) { ret = append(ret, f) })
+	return ret, nil
+}
+
+func hvals(t *txn, args []string) (interface{}, error) {
+	h, err := t.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	hashEach(h, func(_, v string) { ret = append(ret, v) })
+	return ret, nil
+}
+
+func hincrby(t *txn, args []string) (interface{}, error) {
+	delta, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	h, err := t.getHash(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	var i int64
+	if v, ok := hashGet(h, args[1]); ok {
+		if i, err = parseInt(v); err != nil {
+			return nil, err
+		}
+	}
+	i += delta
+	return int(i), h.Put([]byte(args[1]), []byte(strconv.FormatInt(i, 10)))
+}
+
+func hincrbyfloat(t *txn, args []string) (interface{}, error) {
+	delta, err := parseFloat(args[2])
+	if err != nil {
+		return nil, err
+	}
+	h, err := t.getHash(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	var f float64
+	if v, ok := hashGet(h, args[1]); ok {
+		if f, err = parseFloat(v); err != nil {
+			return nil, err
+		}
+	}
+	newStr := storage.FormatFloat(f + delta)
+	return storage.FloatReply(f + delta), h.Put([]byte(args[1]), []byte(newStr))
+}
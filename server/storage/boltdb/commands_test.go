@@ -0,0 +1,79 @@
+package boltdb
+
+import (
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/storage"
+)
+
+func TestSetrange(t *testing.T) {
+	type test struct {
+		name string
+		args []string
+		ret  interface{}
+		err  error
+		val  string
+	}
+
+	tests := []test{
+		{"overwrite", []string{"str", "1", "X"}, 3, nil, "aXc"},
+		{"grows", []string{"str", "5", "X"}, 6, nil, "abc\x00\x00X"},
+		{"missing key", []string{"none", "2", "X"}, 3, nil, "\x00\x00X"},
+		{"empty value", []string{"str", "10", ""}, 3, nil, "abc"},
+		{"empty value missing key", []string{"none", "10", ""}, 0, nil, ""},
+		{"negative offset", []string{"str", "-1", "X"}, nil, errOffset, "abc"},
+		{"oversized offset", []string{"str", strconv.Itoa(storage.MaxStringSize), "X"}, nil, errOffset, "abc"},
+		{"oversized end", []string{"str", strconv.Itoa(storage.MaxStringSize - 1), "XY"}, nil, errOffset, "abc"},
+		{"huge offset", []string{"str", "9223372036854775807", "X"}, nil, errOffset, "abc"},
+		{"wrong type", []string{"hash", "0", "X"}, nil, errWrongType, ""},
+		{"expired", []string{"expired", "1", "X"}, 2, nil, "\x00X"},
+	}
+
+	dir, err := ioutil.TempDir("", "boltdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.db")
+	db, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDB(path)
+
+	for _, tt := range tests {
+		db.Update(func(tx *bolt.Tx) error {
+			tn := &txn{tx: tx, now: time.Now(), writable: true}
+			for _, key := range []string{"str", "hash", "expired", "none"} {
+				if err := tn.delKey(key); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tn.call("set", []string{"str", "abc"})
+			tn.call("hset", []string{"hash", "f", "v"})
+			tn.call("set", []string{"expired", "abc"})
+			tn.setExpiry("expired", tn.now.Add(-time.Second))
+
+			ret, err := tn.call("setrange", tt.args)
+			if err != tt.err {
+				t.Errorf("%s: got err %v, expected %v", tt.name, err, tt.err)
+				return nil
+			} else if ret != tt.ret {
+				t.Errorf("%s: got %#v, expected %#v", tt.name, ret, tt.ret)
+			}
+			if tt.err == errWrongType {
+				return nil
+			}
+			if str, _, _ := tn.getStr(tt.args[0]); str != tt.val {
+				t.Errorf("%s: left value %q, expected %q", tt.name, str, tt.val)
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package mem
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/storage"
+)
+
+// A function implementing a single command. args includes the key as its first
+// element. The store will be locked when it's called
+type cmdFunc func(s *store, args []string) (interface{}, error)
+
+// CommandInfo is a struct which is tied to a command, and describes various
+// properties of the command. All properties are false by default.
+type CommandInfo struct {
+	Modifies bool
+
+	// Internal commands are only used by hyrax itself (e.g. from scripts), and
+	// can't be called by clients
+	Internal bool
+
+	// The number of arguments the command takes, including the key. If
+	// negative it's the minimum number
+	Arity int
+
+	fn cmdFunc
+}
+
+// commandMap is a map of commands to their info structs. It supports the same
+// commands as the redis backend does
+var commandMap = map[string]*CommandInfo{
+
+	//Keys
+	"del":       {Modifies: true, Internal: true, Arity: -1, fn: del},
+	"exists":    {Arity: 1, fn: exists},
+	"expire":    {Modifies: true, Arity: 2, fn: expire},
+	"expireat":  {Modifies: true, Arity: 2, fn: expireat},
+	"persist":   {Modifies: true, Arity: 1, fn: persist},
+	"pexpire":   {Modifies: true, Arity: 2, fn: pexpire},
+	"pexpireat": {Modifies: true, Arity: 2, fn: pexpireat},
+	"pttl":      {Arity: 1, fn: pttl},
+	"ttl":       {Arity: 1, fn: ttl},
+	"type":      {Arity: 1, fn: typeCmd},
+
+	//Strings
+	"append":      {Modifies: true, Arity: 2, fn: appendCmd},
+	"bitcount":    {Arity: -1, fn: bitcount},
+	"decr":        {Modifies: true, Arity: 1, fn: decr},
+	"decrby":      {Modifies: true, Arity: 2, fn: decrby},
+	"get":         {Arity: 1, fn: get},
+	"getbit":      {Arity: 2, fn: getbit},
+	"getrange":    {Arity: 3, fn: getrange},
+	"getset":      {Modifies: true, Arity: 2, fn: getset},
+	"incr":        {Modifies: true, Arity: 1, fn: incr},
+	"incrby":      {Modifies: true, Arity: 2, fn: incrby},
+	"incrbyfloat": {Modifies: true, Arity: 2, fn: incrbyfloat},
+	"psetex":      {Modifies: true, Arity: 3, fn: psetex},
+	"set":         {Modifies: true, Arity: -2, fn: set},
+	"setbit":      {Modifies: true, Arity: 3, fn: setbit},
+	"setex":       {Modifies: true, Arity: 3, fn: setex},
+	"setnx":       {Modifies: true, Arity: 2, fn: setnx},
+	"setrange":    {Modifies: true, Arity: 3, fn: setrange},
+	"strlen":      {Arity: 1, fn: strlen},
+
+	//Hashes
+	"hdel":         {Modifies: true, Arity: -2, fn: hdel},
+	"hexists":      {Arity: 2, fn: hexists},
+	"hget":         {Arity: 2, fn: hget},
+	"hgetall":      {Arity: 1, fn: hgetall},
+	"hincrby":      {Modifies: true, Arity: 3, fn: hincrby},
+	"hincrbyfloat": {Modifies: true, Arity: 3, fn: hincrbyfloat},
+	"hkeys":        {Arity: 1, fn: hkeys},
+	"hlen":         {Arity: 1, fn: hlen},
+	"hmget":        {Arity: -2, fn: hmget},
+	"hset":         {Modifies: true, Arity: 3, fn: hset},
+	"hsetnx":       {Modifies: true, Arity: 3, fn: hsetnx},
+	"hvals":        {Arity: 1, fn: hvals},
+
+	//Lists
+	"lindex":  {Arity: 2, fn: lindex},
+	"linsert": {Modifies: true, Arity: 4, fn: linsert},
+	"llen":    {Arity: 1, fn: llen},
+	"lpop":    {Modifies: true, Arity: 1, fn: lpop},
+	"lpush":   {Modifies: true, Arity: -2, fn: lpush},
+	"lpushx":  {Modifies: true, Arity: 2, fn: lpushx},
+	"lrange":  {Arity: 3, fn: lrange},
+	"lrem":    {Modifies: true, Arity: 3, fn: lrem},
+	"lset":    {Modifies: true, Arity: 3, fn: lset},
+	"ltrim":   {Modifies: true, Arity: 3, fn: ltrim},
+	"rpop":    {Modifies: true, Arity: 1, fn: rpop},
+	"rpush":   {Modifies: true, Arity: -2, fn: rpush},
+	"rpushx":  {Modifies: true, Arity: 2, fn: rpushx},
+
+	//Sets
+	"sadd":        {Modifies: true, Arity: -2, fn: sadd},
+	"scard":       {Arity: 1, fn: scard},
+	"sismember":   {Arity: 2, fn: sismember},
+	"smembers":    {Arity: 1, fn: smembers},
+	"spop":        {Modifies: true, Arity: 1, fn: spop},
+	"srandmember": {Arity: -1, fn: srandmember},
+	"srem":        {Modifies: true, Arity: -2, fn: srem},
+
+	//Sorted Sets
+	"zadd":             {Modifies: true, Arity: -3, fn: zadd},
+	"zcard":            {Arity: 1, fn: zcard},
+	"zcount":           {Arity: 3, fn: zcount},
+	"zincrby":          {Modifies: true, Arity: 3, fn: zincrby},
+	"zrange":           {Arity: -3, fn: zrange},
+	"zrangebyscore":    {Arity: -3, fn: zrangebyscore},
+	"zrank":            {Arity: 2, fn: zrank},
+	"zrem":             {Modifies: true, Arity: -2, fn: zrem},
+	"zremrangebyrank":  {Modifies: true, Arity: 3, fn: zremrangebyrank},
+	"zremrangebyscore": {Modifies: true, Arity: 3, fn: zremrangebyscore},
+	"zrevrange":        {Arity: -3, fn: zrevrange},
+	"zrevrangebyscore": {Arity: -3, fn: zrevrangebyscore},
+	"zrevrank":         {Arity: 2, fn: zrevrank},
+	"zscore":           {Arity: 2, fn: zscore},
+}
+
+func getCommandInfo(cmd string) (*CommandInfo, bool) {
+	cinfo, ok := commandMap[strings.ToLower(cmd)]
+	if ok && cinfo.Internal {
+		return nil, false
+	}
+	return cinfo, ok
+}
+
+// Errors returned by commands, worded the same as redis's
+var (
+	errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInt    = errors.New("ERR value is not an integer or out of range")
+	errNotFloat  = errors.New("ERR value is not a valid float")
+	errSyntax    = errors.New("ERR syntax error")
+	errNoKey     = errors.New("ERR no such key")
+	errRange     = errors.New("ERR index out of range")
+)
+
+func parseInt(s string) (int64, error) {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errNotInt
+	}
+	return i, nil
+}
+
+func parseFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errNotFloat
+	}
+	return f, nil
+}
+
+func formatInt(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
+
+// rangeIndexes normalizes a start and stop index (which may be negative, to
+// count from the end) into a sequence of length n. Returns false if the range
+// is empty
+func rangeIndexes(start, stop int64, n int) (int, int, bool) {
+	ln := int64(n)
+	if start < 0 {
+		start += ln
+	}
+	if stop < 0 {
+		stop += ln
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= ln {
+		stop = ln - 1
+	}
+	if start > stop || start >= ln {
+		return 0, 0, false
+	}
+	return int(start), int(stop), true
+}
+
+func parseRange(startStr, stopStr string, n int) (int, int, bool, error) {
+	start, err := parseInt(startStr)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	stop, err := parseInt(stopStr)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	i, j, ok := rangeIndexes(start, stop, n)
+	return i, j, ok, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Keys
+
+func del(s *store, args []string) (interface{}, error) {
+	n := 0
+	for _, key := range args {
+		if s.get(key) != nil {
+			delete(s.m, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func exists(s *store, args []string) (interface{}, error) {
+	if s.get(args[0]) == nil {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+// expireAt sets the key to expire at the given time, deleting it right away if
+// that's already passed
+func (s *store) expireAt(key string, t time.Time) int {
+	e := s.get(key)
+	if e == nil {
+		return 0
+	}
+	if !t.After(time.Now()) {
+		delete(s.m, key)
+	} else {
+		e.expires = t
+	}
+	return 1
+}
+
+func expireFn(unit time.Duration, absolute bool) cmdFunc {
+	return func(s *store, args []string) (interface{}, error) {
+		i, err := parseInt(args[1])
+		if err != nil {
+			return nil, err
+		}
+		var t time.Time
+		if absolute {
+			t = time.Unix(0, 0).Add(time.Duration(i) * unit)
+		} else {
+			t = time.Now().Add(time.Duration(i) * unit)
+		}
+		return s.expireAt(args[0], t), nil
+	}
+}
+
+var (
+	expire    = expireFn(time.Second, false)
+	expireat  = expireFn(time.Second, true)
+	pexpire   = expireFn(time.Millisecond, false)
+	pexpireat = expireFn(time.Millisecond, true)
+)
+
+func persist(s *store, args []string) (interface{}, error) {
+	e := s.get(args[0])
+	if e == nil || e.expires.IsZero() {
+		return 0, nil
+	}
+	e.expires = time.Time{}
+	return 1, nil
+}
+
+func ttlFn(unit time.Duration) cmdFunc {
+	return func(s *store, args []string) (interface{}, error) {
+		e := s.get(args[0])
+		if e == nil {
+			return -2, nil
+		} else if e.expires.IsZero() {
+			return -1, nil
+		}
+		left := e.expires.Sub(time.Now())
+		return int((left + unit/2) / unit), nil
+	}
+}
+
+var (
+	ttl  = ttlFn(time.Second)
+	pttl = ttlFn(time.Millisecond)
+)
+
+func typeCmd(s *store, args []string) (interface{}, error) {
+	e := s.get(args[0])
+	if e == nil {
+		return storage.Status("none"), nil
+	}
+	switch e.val.(type) {
+	case string:
+		return storage.Status("string"), nil
+	case hash:
+		return storage.Status("hash"), nil
+	case *list:
+		return storage.Status("list"), nil
+	case memberSet:
+		return storage.Status("set"), nil
+	case zset:
+		return storage.Status("zset"), nil
+	}
+	return storage.Status("none"), nil
+}
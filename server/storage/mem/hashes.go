@@ -0,0 +1,199 @@
+package mem
+
+import (
+	"sort"
+
+	"github.com/mediocregopher/hyrax/server/storage"
+)
+
+// The value of a hash key
+type hash map[string]string
+
+// getHash returns the hash at the key. If the key doesn't exist and create is
+// set a new empty hash is stored at it, otherwise nil is returned
+func (s *store) getHash(key string, create bool) (hash, error) {
+	e := s.get(key)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		h := hash{}
+		s.m[key] = &entry{val: h}
+		return h, nil
+	}
+	h, ok := e.val.(hash)
+	if !ok {
+		return nil, errWrongType
+	}
+	return h, nil
+}
+
+// sortedKeys returns the fields of the hash in sorted order, so that commands
+// which return all of them are consistent with each other
+func (h hash) sortedKeys() []string {
+	fields := make([]string, 0, len(h))
+	for f := range h {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func hset(s *store, args []string) (interface{}, error) {
+	h, err := s.getHash(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	_, exists := h[args[1]]
+	h[args[1]] = args[2]
+	if exists {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func hsetnx(s *store, args []string) (interface{}, error) {
+	h, err := s.getHash(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := h[args[1]]; exists {
+		return 0, nil
+	}
+	h[args[1]] = args[2]
+	return 1, nil
+}
+
+func hget(s *store, args []string) (interface{}, error) {
+	h, err := s.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := h[args[1]]; ok {
+		return v, nil
+	}
+	return nil, nil
+}
+
+func hmget(s *store, args []string) (interface{}, error) {
+	h, err := s.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(args)-1)
+	for i, f := range args[1:] {
+		if v, ok := h[f]; ok {
+			vals[i] = v
+		}
+	}
+	return vals, nil
+}
+
+func hdel(s *store, args []string) (interface{}, error) {
+	h, err := s.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, f := range args[1:] {
+		if _, ok := h[f]; ok {
+			delete(h, f)
+			n++
+		}
+	}
+	if h != nil && len(h) == 0 {
+		delete(s.m, args[0])
+	}
+	return n, nil
+}
+
+func hexists(s *store, args []string) (interface{}, error) {
+	h, err := s.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := h[args[1]]; ok {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func hlen(s *store, args []string) (interface{}, error) {
+	h, err := s.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	return len(h), nil
+}
+
+func hgetall(s *store, args []string) (interface{}, error) {
+	h, err := s.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]string, len(h))
+	for f, v := range h {
+		ret[f] = v
+	}
+	return ret, nil
+}
+
+func hkeys(s *store, args []string) (interface{}, error) {
+	h, err := s.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	return h.sortedKeys(), nil
+}
+
+func hvals(s *store, args []string) (interface{}, error) {
+	h, err := s.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	fields := h.sortedKeys()
+	ret := make([]string, len(fields))
+	for i, f := range fields {
+		ret[i] = h[f]
+	}
+	return ret, nil
+}
+
+func hincrby(s *store, args []string) (interface{}, error) {
+	delta, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	h, err := s.getHash(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	var i int64
+	if v, ok := h[args[1]]; ok {
+		if i, err = parseInt(v); err != nil {
+			return nil, err
+		}
+	}
+	i += delta
+	h[args[1]] = formatInt(i)
+	return int(i), nil
+}
+
+func hincrbyfloat(s *store, args []string) (interface{}, error) {
+	delta, err := parseFloat(args[2])
+	if err != nil {
+		return nil, err
+	}
+	h, err := s.getHash(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	var f float64
+	if v, ok := h[args[1]]; ok {
+		if f, err = parseFloat(v); err != nil {
+			return nil, err
+		}
+	}
+	h[args[1]] = storage.FormatFloat(f + delta)
+	return storage.FloatReply(f + delta), nil
+}
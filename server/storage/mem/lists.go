@@ -0,0 +1,245 @@
+package mem
+
+import (
+	"strings"
+
+	"github.com/mediocregopher/hyrax/server/storage"
+)
+
+// The value of a list key
+type list struct {
+	items []string
+}
+
+// getList returns the list at the key. If the key doesn't exist and create is
+// set a new empty list is stored at it, otherwise nil is returned
+func (s *store) getList(key string, create bool) (*list, error) {
+	e := s.get(key)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		l := &list{}
+		s.m[key] = &entry{val: l}
+		return l, nil
+	}
+	l, ok := e.val.(*list)
+	if !ok {
+		return nil, errWrongType
+	}
+	return l, nil
+}
+
+// cleanList removes the list at the key if it's become empty
+func (s *store) cleanList(key string, l *list) {
+	if l != nil && len(l.items) == 0 {
+		delete(s.m, key)
+	}
+}
+
+func pushFn(left, mustExist bool) cmdFunc {
+	return func(s *store, args []string) (interface{}, error) {
+		l, err := s.getList(args[0], !mustExist)
+		if err != nil || l == nil {
+			return 0, err
+		}
+		for _, v := range args[1:] {
+			if left {
+				l.items = append([]string{v}, l.items...)
+			} else {
+				l.items = append(l.items, v)
+			}
+		}
+		return len(l.items), nil
+	}
+}
+
+var (
+	lpush  = pushFn(true, false)
+	lpushx = pushFn(true, true)
+	rpush  = pushFn(false, false)
+	rpushx = pushFn(false, true)
+)
+
+func popFn(left bool) cmdFunc {
+	return func(s *store, args []string) (interface{}, error) {
+		l, err := s.getList(args[0], false)
+		if err != nil || l == nil {
+			return nil, err
+		}
+		var v string
+		if left {
+			v, l.items = l.items[0], l.items[1:]
+		} else {
+			last := len(l.items) - 1
+			v, l.items = l.items[last], l.items[:last]
+		}
+		s.cleanList(args[0], l)
+		return v, nil
+	}
+}
+
+var (
+	lpop = popFn(true)
+	rpop = popFn(false)
+)
+
+func llen(s *store, args []string) (interface{}, error) {
+	l, err := s.getList(args[0], false)
+	if err != nil || l == nil {
+		return 0, err
+	}
+	return len(l.items), nil
+}
+
+// index returns the position in the list of the given (possibly negative)
+// index, or false if it's out of range
+func (l *list) index(idxStr string) (int, bool, error) {
+	i, err := parseInt(idxStr)
+	if err != nil {
+		return 0, false, err
+	}
+	if i < 0 {
+		i += int64(len(l.items))
+	}
+	if i < 0 || i >= int64(len(l.items)) {
+		return 0, false, nil
+	}
+	return int(i), true, nil
+}
+
+func lindex(s *store, args []string) (interface{}, error) {
+	l, err := s.getList(args[0], false)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	i, ok, err := l.index(args[1])
+	if err != nil || !ok {
+		return nil, err
+	}
+	return l.items[i], nil
+}
+
+func lset(s *store, args []string) (interface{}, error) {
+	l, err := s.getList(args[0], false)
+	if err != nil {
+		return nil, err
+	} else if l == nil {
+		return nil, errNoKey
+	}
+	i, ok, err := l.index(args[1])
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errRange
+	}
+	l.items[i] = args[2]
+	return storage.StatusOK, nil
+}
+
+func lrange(s *store, args []string) (interface{}, error) {
+	l, err := s.getList(args[0], false)
+	if err != nil {
+		return nil, err
+	} else if l == nil {
+		return []string{}, nil
+	}
+	i, j, ok, err := parseRange(args[1], args[2], len(l.items))
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return []string{}, nil
+	}
+	ret := make([]string, j+1-i)
+	copy(ret, l.items[i:j+1])
+	return ret, nil
+}
+
+func ltrim(s *store, args []string) (interface{}, error) {
+	l, err := s.getList(args[0], false)
+	if err != nil || l == nil {
+		return storage.StatusOK, err
+	}
+	i, j, ok, err := parseRange(args[1], args[2], len(l.items))
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		l.items = append([]string{}, l.items[i:j+1]...)
+	} else {
+		l.items = nil
+	}
+	s.cleanList(args[0], l)
+	return storage.StatusOK, nil
+}
+
+func lrem(s *store, args []string) (interface{}, error) {
+	count, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	l, err := s.getList(args[0], false)
+	if err != nil || l == nil {
+		return 0, err
+	}
+
+	// Removing from the tail is the same as removing from the head of the
+	// reversed list
+	items := l.items
+	fromTail := count < 0
+	if fromTail {
+		items = reversed(items)
+		count = -count
+	}
+	kept := make([]string, 0, len(items))
+	removed := 0
+	for _, v := range items {
+		if v == args[2] && (count == 0 || int64(removed) < count) {
+			removed++
+			continue
+		}
+		kept = append(kept, v)
+	}
+	if fromTail {
+		kept = reversed(kept)
+	}
+	l.items = kept
+	s.cleanList(args[0], l)
+	return removed, nil
+}
+
+func reversed(items []string) []string {
+	ret := make([]string, len(items))
+	for i, v := range items {
+		ret[len(items)-1-i] = v
+	}
+	return ret
+}
+
+func linsert(s *store, args []string) (interface{}, error) {
+	var after bool
+	switch strings.ToUpper(args[1]) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return nil, errSyntax
+	}
+	l, err := s.getList(args[0], false)
+	if err != nil || l == nil {
+		return 0, err
+	}
+	for i, v := range l.items {
+		if v != args[2] {
+			continue
+		}
+		if after {
+			i++
+		}
+		l.items = append(l.items, "")
+		copy(l.items[i+1:], l.items[i:])
+		l.items[i] = args[3]
+		return len(l.items), nil
+	}
+	return -1, nil
+}
@@ -0,0 +1,245 @@
+package mem
+
+import (
+	"errors"
+	"github.com/grooveshark/golib/gslog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/storage"
+)
+
+// A command into the in-memory store, implements the Command interface
+type MemCommand struct {
+	cmd  string
+	args []interface{}
+}
+
+// Returns a new MemCommand with the given arguments
+func NewMemCommand(cmd string, args ...interface{}) storage.Command {
+	return &MemCommand{
+		cmd:  cmd,
+		args: args,
+	}
+}
+
+func (c *MemCommand) Cmd() string {
+	return c.cmd
+}
+
+func (c *MemCommand) Args() []interface{} {
+	return c.args
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// A single value in the store, and when it expires (zero if never)
+type entry struct {
+	val     interface{}
+	expires time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// A store is a set of keys and their values. All connections with the same
+// address share the same store
+type store struct {
+	sync.Mutex
+	m map[string]*entry
+}
+
+// A mapping of addresses to their stores
+var stores = map[string]*store{}
+var storesLock sync.Mutex
+
+// How often keys which have expired but haven't been touched are cleaned out
+const sweepPeriod = 1 * time.Second
+
+func getStore(addr string) *store {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+	s, ok := stores[addr]
+	if !ok {
+		s = &store{m: map[string]*entry{}}
+		stores[addr] = s
+		go s.sweepSpin()
+	}
+	return s
+}
+
+func (s *store) sweepSpin() {
+	for _ = range time.Tick(sweepPeriod) {
+		now := time.Now()
+		s.Lock()
+		for key, e := range s.m {
+			if e.expired(now) {
+				delete(s.m, key)
+			}
+		}
+		s.Unlock()
+	}
+}
+
+// get returns the entry for the key, or nil if it doesn't exist or has
+// expired. The store must be locked when calling this
+func (s *store) get(key string) *entry {
+	e, ok := s.m[key]
+	if !ok {
+		return nil
+	} else if e.expired(time.Now()) {
+		delete(s.m, key)
+		return nil
+	}
+	return e
+}
+
+// A connection to an in-memory store, implements the Storage interface
+type MemConn struct {
+	addr    string
+	s       *store
+	cmdCh   chan *storage.CommandBundle
+	closeCh chan chan error
+}
+
+// Returns an unconnected in-memory connection structure as per the Storage
+// interface
+func New() storage.Storage {
+	return &MemConn{}
+}
+
+// Implements Connect for Storage. There's nothing to actually connect to, the
+// address is only used to decide which store to use
+func (m *MemConn) Connect(cmdCh chan *storage.CommandBundle,
+	_, addr string, _ ...interface{}) error {
+
+	m.addr = addr
+	m.s = getStore(addr)
+	m.cmdCh = cmdCh
+	m.closeCh = make(chan chan error)
+	go m.spin()
+	return nil
+}
+
+func (m *MemConn) spin() {
+	for {
+		select {
+		case retCh := <-m.closeCh:
+			retCh <- nil
+			return
+
+		case cmdb := <-m.cmdCh:
+			rawret, err := m.cmd(cmdb.Cmd)
+			ret := storage.CommandRet{Ret: rawret, Err: err}
+			select {
+			case cmdb.RetCh <- &ret:
+			case <-time.After(10 * time.Second):
+				gslog.Errorf("MemConn timedout replying to cmd %v", cmdb.Cmd)
+			}
+		}
+	}
+}
+
+func (m *MemConn) cmd(cmd storage.Command) (interface{}, error) {
+	gslog.Debugf("Mem cmd: %v, %v", cmd.Cmd(), cmd.Args())
+
+	name := strings.ToLower(cmd.Cmd())
+	if name == "eval" {
+		return m.eval(cmd.Args())
+	}
+
+	args, err := storage.ArgsToStrs(cmd.Args())
+	if err != nil {
+		return nil, err
+	}
+
+	m.s.Lock()
+	defer m.s.Unlock()
+	return m.s.call(name, args)
+}
+
+// call runs the command against the store. Internal commands are allowed. The
+// store must be locked when calling this
+func (s *store) call(name string, args []string) (interface{}, error) {
+	cinfo, ok := commandMap[name]
+	if !ok {
+		return nil, errors.New("ERR unknown command '" + name + "'")
+	}
+	if (cinfo.Arity >= 0 && len(args) != cinfo.Arity) ||
+		(cinfo.Arity < 0 && len(args) < -cinfo.Arity) {
+		return nil, errors.New(
+			"ERR wrong number of arguments for '" + name + "' command",
+		)
+	}
+	return cinfo.fn(s, args)
+}
+
+// eval runs the go implementation of a script, if one has been registered.
+// Scripts are run with the store locked, so they're atomic just like in redis
+func (m *MemConn) eval(rawArgs []interface{}) (interface{}, error) {
+	if len(rawArgs) < 2 {
+		return nil, errors.New("ERR wrong number of arguments for 'eval' command")
+	}
+	args, err := storage.ArgsToStrs(rawArgs)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := storage.GetScriptFunc(args[0])
+	if !ok {
+		return nil, errors.New("ERR scripts are not supported by this backend")
+	}
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || numKeys < 0 || numKeys > len(args)-2 {
+		return nil, errors.New("ERR invalid number of keys")
+	}
+	keys := args[2 : 2+numKeys]
+	sargs := make([]interface{}, 0, len(args)-2-numKeys)
+	for _, arg := range args[2+numKeys:] {
+		sargs = append(sargs, arg)
+	}
+
+	m.s.Lock()
+	defer m.s.Unlock()
+	call := func(cmd string, cargs ...interface{}) (interface{}, error) {
+		strs, err := storage.ArgsToStrs(cargs)
+		if err != nil {
+			return nil, err
+		}
+		return m.s.call(strings.ToLower(cmd), strs)
+	}
+	return fn(call, keys, sargs)
+}
+
+// Implements NewCommand for Storage
+func (_ *MemConn) NewCommand(cmd string, args ...interface{}) storage.Command {
+	return NewMemCommand(cmd, args...)
+}
+
+// Implements CommandAllowed for Storage
+func (_ *MemConn) CommandAllowed(cmd string) bool {
+	_, ok := getCommandInfo(cmd)
+	return ok
+}
+
+// Implements CommandModifies for Storage
+func (_ *MemConn) CommandModifies(cmd string) bool {
+	cinfo, ok := getCommandInfo(cmd)
+	return ok && cinfo.Modifies
+}
+
+// Implements CommandIsAdmin for Storage. There are no administrative commands
+// so this is always false
+func (_ *MemConn) CommandIsAdmin(_ string) bool {
+	return false
+}
+
+// Implements Close for Storage. The store itself is kept around, so that any
+// other connections to it still work
+func (m *MemConn) Close() error {
+	retCh := make(chan error)
+	m.closeCh <- retCh
+	return <-retCh
+}
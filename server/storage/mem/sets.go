@@ -0,0 +1,156 @@
+package mem
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// The value of a set key
+type memberSet map[string]bool
+
+// getSet returns the set at the key. If the key doesn't exist and create is set
+// a new empty set is stored at it, otherwise nil is returned
+func (s *store) getSet(key string, create bool) (memberSet, error) {
+	e := s.get(key)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		st := memberSet{}
+		s.m[key] = &entry{val: st}
+		return st, nil
+	}
+	st, ok := e.val.(memberSet)
+	if !ok {
+		return nil, errWrongType
+	}
+	return st, nil
+}
+
+// members returns the members of the set in sorted order
+func (st memberSet) members() []string {
+	ms := make([]string, 0, len(st))
+	for m := range st {
+		ms = append(ms, m)
+	}
+	sort.Strings(ms)
+	return ms
+}
+
+func sadd(s *store, args []string) (interface{}, error) {
+	st, err := s.getSet(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, m := range args[1:] {
+		if !st[m] {
+			st[m] = true
+			n++
+		}
+	}
+	return n, nil
+}
+
+func srem(s *store, args []string) (interface{}, error) {
+	st, err := s.getSet(args[0], false)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	n := 0
+	for _, m := range args[1:] {
+		if st[m] {
+			delete(st, m)
+			n++
+		}
+	}
+	if len(st) == 0 {
+		delete(s.m, args[0])
+	}
+	return n, nil
+}
+
+func scard(s *store, args []string) (interface{}, error) {
+	st, err := s.getSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	return len(st), nil
+}
+
+func sismember(s *store, args []string) (interface{}, error) {
+	st, err := s.getSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	if st[args[1]] {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func smembers(s *store, args []string) (interface{}, error) {
+	st, err := s.getSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	return st.members(), nil
+}
+
+func spop(s *store, args []string) (interface{}, error) {
+	st, err := s.getSet(args[0], false)
+	if err != nil || st == nil {
+		return nil, err
+	}
+	ms := st.members()
+	m := ms[rand.Intn(len(ms))]
+	delete(st, m)
+	if len(st) == 0 {
+		delete(s.m, args[0])
+	}
+	return m, nil
+}
+
+func srandmember(s *store, args []string) (interface{}, error) {
+	st, err := s.getSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	ms := st.members()
+
+	if len(args) == 1 {
+		if len(ms) == 0 {
+			return nil, nil
+		}
+		return ms[rand.Intn(len(ms))], nil
+	} else if len(args) != 2 {
+		return nil, errSyntax
+	}
+
+	count, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if len(ms) == 0 {
+		return []string{}, nil
+	}
+
+	// A negative count may return the same member more than once, a positive
+	// one returns distinct members
+	if count < 0 {
+		ret := make([]string, -count)
+		for i := range ret {
+			ret[i] = ms[rand.Intn(len(ms))]
+		}
+		return ret, nil
+	}
+	perm := rand.Perm(len(ms))
+	if count > int64(len(ms)) {
+		count = int64(len(ms))
+	}
+	ret := make([]string, count)
+	for i := range ret {
+		ret[i] = ms[perm[i]]
+	}
+	return ret, nil
+}
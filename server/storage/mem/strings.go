@@ -0,0 +1,314 @@
+package mem
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/storage"
+)
+
+var errExpireTime = errors.New("ERR invalid expire time")
+var errOffset = errors.New("ERR offset is out of range")
+var errBit = errors.New("ERR bit is not an integer or out of range")
+
+// getStr returns the string value of the key, or false if the key doesn't
+// exist
+func (s *store) getStr(key string) (string, bool, error) {
+	e := s.get(key)
+	if e == nil {
+		return "", false, nil
+	}
+	str, ok := e.val.(string)
+	if !ok {
+		return "", false, errWrongType
+	}
+	return str, true, nil
+}
+
+// setStr sets the string value of the key. If keepTTL is set and the key
+// already exists its expiry is left as is, otherwise it's cleared
+func (s *store) setStr(key, val string, keepTTL bool) {
+	if e := s.get(key); e != nil && keepTTL {
+		e.val = val
+		return
+	}
+	s.m[key] = &entry{val: val}
+}
+
+func get(s *store, args []string) (interface{}, error) {
+	str, ok, err := s.getStr(args[0])
+	if err != nil || !ok {
+		return nil, err
+	}
+	return str, nil
+}
+
+func set(s *store, args []string) (interface{}, error) {
+	var expires time.Time
+	var nx, xx bool
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "NX":
+			nx = true
+		case opt == "XX":
+			xx = true
+		case (opt == "EX" || opt == "PX") && i+1 < len(args):
+			n, err := parseInt(args[i+1])
+			if err != nil {
+				return nil, err
+			} else if n <= 0 {
+				return nil, errExpireTime
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			expires = time.Now().Add(time.Duration(n) * unit)
+			i++
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	exists := s.get(args[0]) != nil
+	if (nx && exists) || (xx && !exists) {
+		return nil, nil
+	}
+	s.m[args[0]] = &entry{val: args[1], expires: expires}
+	return storage.StatusOK, nil
+}
+
+func setnx(s *store, args []string) (interface{}, error) {
+	if s.get(args[0]) != nil {
+		return 0, nil
+	}
+	s.setStr(args[0], args[1], false)
+	return 1, nil
+}
+
+func setexFn(unit time.Duration) cmdFunc {
+	return func(s *store, args []string) (interface{}, error) {
+		n, err := parseInt(args[1])
+		if err != nil {
+			return nil, err
+		} else if n <= 0 {
+			return nil, errExpireTime
+		}
+		s.m[args[0]] = &entry{
+			val:     args[2],
+			expires: time.Now().Add(time.Duration(n) * unit),
+		}
+		return storage.StatusOK, nil
+	}
+}
+
+var (
+	setex  = setexFn(time.Second)
+	psetex = setexFn(time.Millisecond)
+)
+
+func getset(s *store, args []string) (interface{}, error) {
+	old, ok, err := s.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+	s.setStr(args[0], args[1], false)
+	if !ok {
+		return nil, nil
+	}
+	return old, nil
+}
+
+func appendCmd(s *store, args []string) (interface{}, error) {
+	str, _, err := s.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+	str += args[1]
+	s.setStr(args[0], str, true)
+	return len(str), nil
+}
+
+func strlen(s *store, args []string) (interface{}, error) {
+	str, _, err := s.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return len(str), nil
+}
+
+// incrBy adds delta to the integer value of the key, treating a missing key as
+// 0, and returns the new value
+func (s *store) incrBy(key string, delta int64) (interface{}, error) {
+	str, ok, err := s.getStr(key)
+	if err != nil {
+		return nil, err
+	}
+	var i int64
+	if ok {
+		if i, err = parseInt(str); err != nil {
+			return nil, err
+		}
+	}
+	i += delta
+	s.setStr(key, formatInt(i), true)
+	return int(i), nil
+}
+
+func incr(s *store, args []string) (interface{}, error) {
+	return s.incrBy(args[0], 1)
+}
+
+func decr(s *store, args []string) (interface{}, error) {
+	return s.incrBy(args[0], -1)
+}
+
+func incrby(s *store, args []string) (interface{}, error) {
+	delta, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return s.incrBy(args[0], delta)
+}
+
+func decrby(s *store, args []string) (interface{}, error) {
+	delta, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return s.incrBy(args[0], -delta)
+}
+
+func incrbyfloat(s *store, args []string) (interface{}, error) {
+	delta, err := parseFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	str, ok, err := s.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+	var f float64
+	if ok {
+		if f, err = parseFloat(str); err != nil {
+			return nil, err
+		}
+	}
+	s.setStr(args[0], storage.FormatFloat(f+delta), true)
+	return storage.FloatReply(f + delta), nil
+}
+
+func getrange(s *store, args []string) (interface{}, error) {
+	str, _, err := s.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+	i, j, ok, err := parseRange(args[1], args[2], len(str))
+	if err != nil || !ok {
+		return "", err
+	}
+	return str[i : j+1], nil
+}
+
+func setrange(s *store, args []string) (interface{}, error) {
+	offset, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	} else if offset < 0 || offset > storage.MaxStringSize-int64(len(args[2])) {
+		return nil, errOffset
+	}
+	str, _, err := s.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if args[2] == "" {
+		return len(str), nil
+	}
+
+	b := []byte(str)
+	if end := int(offset) + len(args[2]); end > len(b) {
+		b = append(b, make([]byte, end-len(b))...)
+	}
+	copy(b[offset:], args[2])
+	s.setStr(args[0], string(b), true)
+	return len(b), nil
+}
+
+func getbit(s *store, args []string) (interface{}, error) {
+	offset, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	} else if offset < 0 || offset > storage.MaxBitOffset {
+		return nil, errOffset
+	}
+	str, _, err := s.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+	byteI := int(offset / 8)
+	if byteI >= len(str) {
+		return 0, nil
+	}
+	return int(str[byteI]>>(7-uint(offset%8))) & 1, nil
+}
+
+func setbit(s *store, args []string) (interface{}, error) {
+	offset, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	} else if offset < 0 || offset > storage.MaxBitOffset {
+		return nil, errOffset
+	}
+	if args[2] != "0" && args[2] != "1" {
+		return nil, errBit
+	}
+	str, _, err := s.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	b := []byte(str)
+	byteI := int(offset / 8)
+	if byteI >= len(b) {
+		b = append(b, make([]byte, byteI+1-len(b))...)
+	}
+	mask := byte(1) << (7 - uint(offset%8))
+	old := 0
+	if b[byteI]&mask != 0 {
+		old = 1
+	}
+	if args[2] == "1" {
+		b[byteI] |= mask
+	} else {
+		b[byteI] &^= mask
+	}
+	s.setStr(args[0], string(b), true)
+	return old, nil
+}
+
+func bitcount(s *store, args []string) (interface{}, error) {
+	str, _, err := s.getStr(args[0])
+	if err != nil {
+		return nil, err
+	}
+	switch len(args) {
+	case 1:
+	case 3:
+		i, j, ok, err := parseRange(args[1], args[2], len(str))
+		if err != nil || !ok {
+			return 0, err
+		}
+		str = str[i : j+1]
+	default:
+		return nil, errSyntax
+	}
+
+	n := 0
+	for i := 0; i < len(str); i++ {
+		for b := str[i]; b != 0; b &= b - 1 {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,71 @@
+package mem
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/storage"
+)
+
+func TestOffsets(t *testing.T) {
+	type test struct {
+		name string
+		cmd  string
+		args []string
+		ret  interface{}
+		err  error
+		val  string
+	}
+
+	maxStr := strconv.Itoa(storage.MaxStringSize)
+	maxBit := strconv.Itoa(storage.MaxBitOffset)
+	tests := []test{
+		{"setrange", "setrange", []string{"str", "1", "X"}, 3, nil, "aXc"},
+		{"setrange grows", "setrange", []string{"str", "5", "X"}, 6, nil, "abc\x00\x00X"},
+		{"setrange missing key", "setrange", []string{"none", "2", "X"}, 3, nil, ""},
+		{"setrange empty value", "setrange", []string{"str", "10", ""}, 3, nil, "abc"},
+		{"setrange empty value missing key", "setrange", []string{"none", "10", ""}, 0, nil, ""},
+		{"setrange negative offset", "setrange", []string{"str", "-1", "X"}, nil, errOffset, "abc"},
+		{"setrange oversized offset", "setrange", []string{"str", maxStr, "X"}, nil, errOffset, "abc"},
+		{"setrange oversized end", "setrange", []string{"str", strconv.Itoa(storage.MaxStringSize - 1), "XY"}, nil, errOffset, "abc"},
+		{"setrange huge offset", "setrange", []string{"str", "9223372036854775807", "X"}, nil, errOffset, "abc"},
+		{"setrange wrong type", "setrange", []string{"hash", "0", "X"}, nil, errWrongType, ""},
+		{"setrange expired", "setrange", []string{"expired", "1", "X"}, 2, nil, ""},
+
+		{"getbit", "getbit", []string{"str", "1"}, 1, nil, "abc"},
+		{"getbit past end", "getbit", []string{"str", maxBit}, 0, nil, "abc"},
+		{"getbit negative offset", "getbit", []string{"str", "-1"}, nil, errOffset, "abc"},
+		{"getbit oversized offset", "getbit", []string{"str", strconv.Itoa(storage.MaxBitOffset + 1)}, nil, errOffset, "abc"},
+		{"getbit wrong type", "getbit", []string{"hash", "0"}, nil, errWrongType, ""},
+		{"getbit expired", "getbit", []string{"expired", "1"}, 0, nil, ""},
+
+		{"setbit", "setbit", []string{"str", "7", "0"}, 1, nil, "`bc"},
+		{"setbit grows", "setbit", []string{"str", "31", "1"}, 0, nil, "abc\x01"},
+		{"setbit negative offset", "setbit", []string{"str", "-1", "1"}, nil, errOffset, "abc"},
+		{"setbit oversized offset", "setbit", []string{"str", strconv.Itoa(storage.MaxBitOffset + 1), "1"}, nil, errOffset, "abc"},
+		{"setbit bad bit", "setbit", []string{"str", "0", "2"}, nil, errBit, "abc"},
+		{"setbit wrong type", "setbit", []string{"hash", "0", "1"}, nil, errWrongType, ""},
+		{"setbit expired", "setbit", []string{"expired", "0", "1"}, 0, nil, ""},
+	}
+
+	for _, tt := range tests {
+		s := &store{m: map[string]*entry{
+			"str":     {val: "abc"},
+			"hash":    {val: hash{"f": "v"}},
+			"expired": {val: "abc", expires: time.Now().Add(-time.Second)},
+		}}
+		ret, err := s.call(tt.cmd, tt.args)
+		if err != tt.err {
+			t.Errorf("%s: got err %v, expected %v", tt.name, err, tt.err)
+			continue
+		} else if ret != tt.ret {
+			t.Errorf("%s: got %#v, expected %#v", tt.name, ret, tt.ret)
+		}
+		if tt.args[0] == "str" {
+			if str, _, _ := s.getStr("str"); str != tt.val {
+				t.Errorf("%s: left value %q, expected %q", tt.name, str, tt.val)
+			}
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package mem
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mediocregopher/hyrax/server/storage"
+)
+
+var errMinMax = errors.New("ERR min or max is not a float")
+
+// The value of a sorted set key, a mapping of members to their scores
+type zset map[string]float64
+
+type zmember struct {
+	member string
+	score  float64
+}
+
+// getZSet returns the sorted set at the key. If the key doesn't exist and
+// create is set a new empty sorted set is stored at it, otherwise nil is
+// returned
+func (s *store) getZSet(key string, create bool) (zset, error) {
+	e := s.get(key)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		z := zset{}
+		s.m[key] = &entry{val: z}
+		return z, nil
+	}
+	z, ok := e.val.(zset)
+	if !ok {
+		return nil, errWrongType
+	}
+	return z, nil
+}
+
+// sorted returns the members of the sorted set ordered by score, with members
+// with the same score ordered lexicographically
+func (z zset) sorted() []zmember {
+	zms := make([]zmember, 0, len(z))
+	for m, score := range z {
+		zms = append(zms, zmember{m, score})
+	}
+	sort.Sort(zmemberSlice(zms))
+	return zms
+}
+
+type zmemberSlice []zmember
+
+func (s zmemberSlice) Len() int      { return len(s) }
+func (s zmemberSlice) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s zmemberSlice) Less(i, j int) bool {
+	if s[i].score != s[j].score {
+		return s[i].score < s[j].score
+	}
+	return s[i].member < s[j].member
+}
+
+// A min or max score, as given to commands like zrangebyscore
+type scoreBound struct {
+	score     float64
+	exclusive bool
+}
+
+func parseScoreBound(str string) (scoreBound, error) {
+	var b scoreBound
+	if strings.HasPrefix(str, "(") {
+		b.exclusive = true
+		str = str[1:]
+	}
+	switch strings.ToLower(str) {
+	case "-inf":
+		b.score = math.Inf(-1)
+	case "+inf", "inf":
+		b.score = math.Inf(1)
+	default:
+		f, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return b, errMinMax
+		}
+		b.score = f
+	}
+	return b, nil
+}
+
+func inScoreRange(score float64, min, max scoreBound) bool {
+	if score < min.score || (min.exclusive && score == min.score) {
+		return false
+	}
+	if score > max.score || (max.exclusive && score == max.score) {
+		return false
+	}
+	return true
+}
+
+// byScore returns the members of the sorted set within the given bounds, in
+// order
+func (z zset) byScore(minStr, maxStr string) ([]zmember, error) {
+	min, err := parseScoreBound(minStr)
+	if err != nil {
+		return nil, err
+	}
+	max, err := parseScoreBound(maxStr)
+	if err != nil {
+		return nil, err
+	}
+	zms := []zmember{}
+	for _, zm := range z.sorted() {
+		if inScoreRange(zm.score, min, max) {
+			zms = append(zms, zm)
+		}
+	}
+	return zms, nil
+}
+
+func reversedZMembers(zms []zmember) []zmember {
+	ret := make([]zmember, len(zms))
+	for i, zm := range zms {
+		ret[len(zms)-1-i] = zm
+	}
+	return ret
+}
+
+// zmembersReply returns the members as a list, with each followed by its score
+// if withScores is set
+func zmembersReply(zms []zmember, withScores bool) []string {
+	ret := make([]string, 0, 2*len(zms))
+	for _, zm := range zms {
+		ret = append(ret, zm.member)
+		if withScores {
+			ret = append(ret, storage.FormatFloat(zm.score))
+		}
+	}
+	return ret
+}
+
+func (s *store) cleanZSet(key string, z zset) {
+	if z != nil && len(z) == 0 {
+		delete(s.m, key)
+	}
+}
+
+func zadd(s *store, args []string) (interface{}, error) {
+	if len(args)%2 != 1 {
+		return nil, errSyntax
+	}
+	zms := make([]zmember, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		score, err := parseFloat(args[i])
+		if err != nil {
+			return nil, err
+		}
+		zms = append(zms, zmember{args[i+1], score})
+	}
+
+	z, err := s.getZSet(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, zm := range zms {
+		if _, ok := z[zm.member]; !ok {
+			n++
+		}
+		z[zm.member] = zm.score
+	}
+	return n, nil
+}
+
+func zincrby(s *store, args []string) (interface{}, error) {
+	delta, err := parseFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	z, err := s.getZSet(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	z[args[2]] += delta
+	return storage.FloatReply(z[args[2]]), nil
+}
+
+func zrem(s *store, args []string) (interface{}, error) {
+	z, err := s.getZSet(args[0], false)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	n := 0
+	for _, m := range args[1:] {
+		if _, ok := z[m]; ok {
+			delete(z, m)
+			n++
+		}
+	}
+	s.cleanZSet(args[0], z)
+	return n, nil
+}
+
+func zcard(s *store, args []string) (interface{}, error) {
+	z, err := s.getZSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	return len(z), nil
+}
+
+func zscore(s *store, args []string) (interface{}, error) {
+	z, err := s.getZSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	if score, ok := z[args[1]]; ok {
+		return storage.FloatReply(score), nil
+	}
+	return nil, nil
+}
+
+func zcount(s *store, args []string) (interface{}, error) {
+	z, err := s.getZSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	zms, err := z.byScore(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return len(zms), nil
+}
+
+func zrankFn(rev bool) cmdFunc {
+	return func(s *store, args []string) (interface{}, error) {
+		z, err := s.getZSet(args[0], false)
+		if err != nil {
+			return nil, err
+		}
+		zms := z.sorted()
+		for i, zm := range zms {
+			if zm.member != args[1] {
+				continue
+			} else if rev {
+				return len(zms) - 1 - i, nil
+			}
+			return i, nil
+		}
+		return nil, nil
+	}
+}
+
+var (
+	zrank    = zrankFn(false)
+	zrevrank = zrankFn(true)
+)
+
+func zrangeFn(rev bool) cmdFunc {
+	return func(s *store, args []string) (interface{}, error) {
+		withScores := false
+		switch {
+		case len(args) == 4 && strings.ToUpper(args[3]) == "WITHSCORES":
+			withScores = true
+		case len(args) != 3:
+			return nil, errSyntax
+		}
+
+		z, err := s.getZSet(args[0], false)
+		if err != nil {
+			return nil, err
+		}
+		zms := z.sorted()
+		if rev {
+			zms = reversedZMembers(zms)
+		}
+		i, j, ok, err := parseRange(args[1], args[2], len(zms))
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			return []string{}, nil
+		}
+		return zmembersReply(zms[i:j+1], withScores), nil
+	}
+}
+
+var (
+	zrange    = zrangeFn(false)
+	zrevrange = zrangeFn(true)
+)
+
+func zrangebyscoreFn(rev bool) cmdFunc {
+	return func(s *store, args []string) (interface{}, error) {
+		withScores := false
+		offset, count := int64(0), int64(-1)
+		for i := 3; i < len(args); i++ {
+			switch opt := strings.ToUpper(args[i]); {
+			case opt == "WITHSCORES":
+				withScores = true
+			case opt == "LIMIT" && i+2 < len(args):
+				var err error
+				if offset, err = parseInt(args[i+1]); err != nil {
+					return nil, err
+				}
+				if count, err = parseInt(args[i+2]); err != nil {
+					return nil, err
+				}
+				i += 2
+			default:
+				return nil, errSyntax
+			}
+		}
+
+		z, err := s.getZSet(args[0], false)
+		if err != nil {
+			return nil, err
+		}
+		// The reverse version takes its bounds as max then min
+		min, max := args[1], args[2]
+		if rev {
+			min, max = max, min
+		}
+		zms, err := z.byScore(min, max)
+		if err != nil {
+			return nil, err
+		}
+		if rev {
+			zms = reversedZMembers(zms)
+		}
+
+		if offset < 0 || offset >= int64(len(zms)) {
+			return []string{}, nil
+		}
+		zms = zms[offset:]
+		if count >= 0 && count < int64(len(zms)) {
+			zms = zms[:count]
+		}
+		return zmembersReply(zms, withScores), nil
+	}
+}
+
+var (
+	zrangebyscore    = zrangebyscoreFn(false)
+	zrevrangebyscore = zrangebyscoreFn(true)
+)
+
+func zremrangebyrank(s *store, args []string) (interface{}, error) {
+	z, err := s.getZSet(args[0], false)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	zms := z.sorted()
+	i, j, ok, err := parseRange(args[1], args[2], len(zms))
+	if err != nil || !ok {
+		return 0, err
+	}
+	for _, zm := range zms[i : j+1] {
+		delete(z, zm.member)
+	}
+	s.cleanZSet(args[0], z)
+	return j + 1 - i, nil
+}
+
+func zremrangebyscore(s *store, args []string) (interface{}, error) {
+	z, err := s.getZSet(args[0], false)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	zms, err := z.byScore(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	for _, zm := range zms {
+		delete(z, zm.member)
+	}
+	s.cleanZSet(args[0], z)
+	return len(zms), nil
+}
@@ -0,0 +1,142 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fzzy/radix/redis"
+	"github.com/grooveshark/golib/gslog"
+	"strconv"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/config"
+	"github.com/mediocregopher/hyrax/server/storage"
+)
+
+var blockingArgs = errors.New("ERR blocking commands take only a timeout argument")
+
+// Implements CommandBlocks for Blocker
+func (_ *RedisConn) CommandBlocks(cmd string) bool {
+	cinfo, ok := getCommandInfo(cmd)
+	return ok && cinfo.Blocks
+}
+
+// A connection which a blocking command has finished with, and the address
+// it's connected to
+type idleConn struct {
+	conn *redis.Client
+	addr string
+}
+
+// blockingConn returns an idle connection to the address if there is one, or
+// dials a new one. Idle connections to any other address (e.g. an old primary
+// from before a failover) are closed
+func (r *RedisConn) blockingConn(conntype, addr string) (*redis.Client, error) {
+	for {
+		select {
+		case ic := <-r.idle:
+			if ic.addr == addr {
+				return ic.conn, nil
+			}
+			ic.conn.Close()
+		default:
+			return redis.Dial(conntype, addr)
+		}
+	}
+}
+
+// putIdle keeps the connection to be used by a later blocking command, or
+// closes it if there are already enough idle ones
+func (r *RedisConn) putIdle(conn *redis.Client, addr string) {
+	select {
+	case r.idle <- idleConn{conn, addr}:
+	default:
+		conn.Close()
+	}
+}
+
+// closeIdle closes all the idle connections
+func (r *RedisConn) closeIdle() {
+	for {
+		select {
+		case ic := <-r.idle:
+			ic.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// Implements BlockingCmd for Blocker. Blocking commands may only be given the
+// key and a timeout in seconds, which must be between 1 and the configured
+// storage-blocking-timeout. Only a single key is allowed so that the command
+// always goes to the shard that key lives on.
+//
+// Each command gets a connection to itself for as long as it's running. When
+// it completes the connection is kept, up to storage-blocking-max of them, so
+// later commands don't each have to dial a new one. The StorageUnit makes sure
+// no more than storage-blocking-max commands are running at once.
+func (r *RedisConn) BlockingCmd(conntype, addr string, cmd storage.Command,
+	cancelCh <-chan struct{}) (interface{}, error) {
+
+	args := cmd.Args()
+	if len(args) != 2 {
+		return nil, blockingArgs
+	}
+	timeout, err := strconv.Atoi(fmt.Sprint(args[1]))
+	maxTimeout := int(config.StorageBlockingTimeout / time.Second)
+	if err != nil || timeout < 1 || timeout > maxTimeout {
+		return nil, fmt.Errorf(
+			"ERR timeout must be between 1 and %d seconds", maxTimeout,
+		)
+	}
+
+	// The sentinel is only set on Connect, so it's safe to read here. When
+	// there is one addr is the master name, not an address
+	if r.sentinel != nil {
+		addr = r.sentinel.addr()
+	}
+	conn, err := r.blockingConn(conntype, addr)
+	if err != nil {
+		gslog.Errorf("connecting to redis at %s: %s", addr, err)
+		if r.sentinel != nil {
+			return nil, storage.RetryErr
+		}
+		return nil, err
+	}
+
+	type result struct {
+		ret interface{}
+		err error
+
+		// Whether the connection is still fine to use. Error replies might
+		// mean it's broken, so it isn't reused after one
+		reusable bool
+	}
+	retCh := make(chan result, 1)
+	go func() {
+		gslog.Debugf("Redis blocking cmd: %v, %v", cmd.Cmd(), args)
+		reply := conn.Cmd(cmd.Cmd(), args...)
+		dreply, err := decodeReply(reply)
+		gslog.Debugf("Redis blocking reply: %v, %v", dreply, err)
+		retCh <- result{dreply, err, reply.Type != redis.ErrorReply}
+	}()
+
+	select {
+	case res := <-retCh:
+		if res.reusable {
+			r.putIdle(conn, addr)
+		} else {
+			conn.Close()
+		}
+		return res.ret, res.err
+	case <-cancelCh:
+		// Closing the connection makes redis drop the waiting command, and
+		// makes the Cmd call above return. If the command completed at the
+		// same time its return is still given back rather than dropped
+		conn.Close()
+		if res := <-retCh; res.err == nil && res.ret != nil {
+			return res.ret, res.err
+		}
+		return nil, storage.CancelledErr
+	}
+}
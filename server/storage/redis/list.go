@@ -1,16 +1,43 @@
 package redis
 
 import (
+	"bufio"
+	"fmt"
+	"os"
 	"strings"
+
+	"github.com/mediocregopher/hyrax/server/config"
 )
 
 // CommandInfo is a struct which is tied to a command, and describes various
 // properties of the command. All properties are false by default.
 type CommandInfo struct {
 	Modifies bool
+	Admin    bool
+
+	// Blocking commands are run on a connection of their own, see BlockingCmd
+	Blocks bool
+
+	// The shape replies to the command are decoded into, one of the reply
+	// constants
+	Reply int
 }
 
-// commandMap is a map of commands to their info structs
+// The shapes replies can be decoded into, beyond what redis itself returns
+const (
+	// Returned as-is
+	replyDefault = iota
+
+	// A flat list of field/value pairs, which is decoded into a map
+	replyMap
+
+	// A float formatted as a string, which is decoded into a number if
+	// storage-typed-replies is set
+	replyFloat
+)
+
+// commandMap is a map of commands to their info structs. These are the
+// defaults, which can be changed with LoadCommands
 var commandMap = map[string]*CommandInfo{
 
 	//Keys
@@ -35,7 +62,7 @@ var commandMap = map[string]*CommandInfo{
 	"getset":      {Modifies: true},
 	"incr":        {Modifies: true},
 	"incrby":      {Modifies: true},
-	"incrbyfloat": {Modifies: true},
+	"incrbyfloat": {Modifies: true, Reply: replyFloat},
 	"psetex":      {Modifies: true},
 	"set":         {Modifies: true},
 	"setbit":      {Modifies: true},
@@ -48,9 +75,9 @@ var commandMap = map[string]*CommandInfo{
 	"hdel":         {Modifies: true},
 	"hexists":      {},
 	"hget":         {},
-	"hgetall":      {}, // Returns map
+	"hgetall":      {Reply: replyMap},
 	"hincrby":      {Modifies: true},
-	"hincrbyfloat": {Modifies: true},
+	"hincrbyfloat": {Modifies: true, Reply: replyFloat},
 	"hkeys":        {},
 	"hlen":         {},
 	"hmget":        {},
@@ -59,8 +86,8 @@ var commandMap = map[string]*CommandInfo{
 	"hvals":        {},
 
 	//Lists
-	//blpop
-	//brpop
+	"blpop":   {Modifies: true, Blocks: true},
+	"brpop":   {Modifies: true, Blocks: true},
 	"lindex":  {},
 	"linsert": {Modifies: true},
 	"llen":    {},
@@ -85,10 +112,12 @@ var commandMap = map[string]*CommandInfo{
 	"srem":        {Modifies: true},
 
 	//Sorted Sets
+	"bzpopmax":         {Modifies: true, Blocks: true},
+	"bzpopmin":         {Modifies: true, Blocks: true},
 	"zadd":             {Modifies: true},
 	"zcard":            {},
 	"zcount":           {},
-	"zincrby":          {Modifies: true},
+	"zincrby":          {Modifies: true, Reply: replyFloat},
 	"zrange":           {},
 	"zrangebyscore":    {},
 	"zrank":            {},
@@ -98,7 +127,162 @@ var commandMap = map[string]*CommandInfo{
 	"zrevrange":        {},
 	"zrevrangebyscore": {},
 	"zrevrank":         {},
-	"zscore":           {},
+	"zscore":           {Reply: replyFloat},
+}
+
+// Commands which block on a single key with a timeout, and so are always given
+// their own connection
+var blockingCommands = map[string]bool{
+	"blpop":    true,
+	"brpop":    true,
+	"bzpopmax": true,
+	"bzpopmin": true,
+}
+
+// Commands which can't be allowed. Some change the state of the connection
+// they're run on or tie it up in ways BlockingCmd can't handle, and so can't be
+// run on a pooled connection. Scripts would get around per-key auth and the
+// keys hyrax reserves for itself, see the scripts setting instead.
+var unsafeCommands = map[string]bool{
+	"auth":         true,
+	"blmove":       true,
+	"blmpop":       true,
+	"brpoplpush":   true,
+	"client":       true,
+	"discard":      true,
+	"eval":         true,
+	"evalsha":      true,
+	"exec":         true,
+	"hello":        true,
+	"monitor":      true,
+	"multi":        true,
+	"psubscribe":   true,
+	"psync":        true,
+	"punsubscribe": true,
+	"quit":         true,
+	"readonly":     true,
+	"readwrite":    true,
+	"reset":        true,
+	"script":       true,
+	"select":       true,
+	"subscribe":    true,
+	"sync":         true,
+	"unsubscribe":  true,
+	"unwatch":      true,
+	"wait":         true,
+	"watch":        true,
+	"xread":        true,
+	"xreadgroup":   true,
+}
+
+// Commands which act on more than one key. Commands are sent to the shard their
+// first key lives on, so these can't be allowed when there's more than one
+var multiKeyCommands = map[string]bool{
+	"bitop":       true,
+	"copy":        true,
+	"del":         true,
+	"lmove":       true,
+	"mget":        true,
+	"mset":        true,
+	"msetnx":      true,
+	"pfcount":     true,
+	"pfmerge":     true,
+	"rename":      true,
+	"renamenx":    true,
+	"rpoplpush":   true,
+	"sdiff":       true,
+	"sdiffstore":  true,
+	"sinter":      true,
+	"sinterstore": true,
+	"smove":       true,
+	"sunion":      true,
+	"sunionstore": true,
+	"touch":       true,
+	"unlink":      true,
+	"zdiffstore":  true,
+	"zinterstore": true,
+	"zrangestore": true,
+	"zunionstore": true,
+}
+
+// LoadCommands changes which commands are allowed according to the file at the
+// given path. Each line of the file is a command followed by any of the flags
+// "modifies", "admin", "map" and "float", which adds the command (replacing any
+// existing entry for it), or a command prefixed with "-", which removes it.
+// Blank lines and lines starting with "#" are ignored. The changes are only
+// made if the whole file is valid. This should only be called before any
+// connections are made.
+func LoadCommands(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	m := make(map[string]*CommandInfo, len(commandMap))
+	for cmd, cinfo := range commandMap {
+		m[cmd] = cinfo
+	}
+
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		cmd := strings.ToLower(fields[0])
+		remove := strings.HasPrefix(cmd, "-")
+		cmd = strings.TrimPrefix(cmd, "-")
+		if cmd == "" {
+			return fmt.Errorf("%s:%d: missing command", path, lineNum)
+		} else if seen[cmd] {
+			return fmt.Errorf("%s:%d: %s given more than once", path, lineNum, cmd)
+		}
+		seen[cmd] = true
+
+		if remove {
+			if len(fields) > 1 {
+				return fmt.Errorf("%s:%d: flags given for removed command %s",
+					path, lineNum, cmd)
+			}
+			delete(m, cmd)
+			continue
+		}
+
+		if unsafeCommands[cmd] {
+			return fmt.Errorf("%s:%d: %s can't be allowed", path, lineNum, cmd)
+		} else if multiKeyCommands[cmd] && len(config.StorageShards) > 1 {
+			return fmt.Errorf("%s:%d: %s can't be allowed with sharding",
+				path, lineNum, cmd)
+		}
+		cinfo := &CommandInfo{Blocks: blockingCommands[cmd]}
+		if old, ok := commandMap[cmd]; ok {
+			cinfo.Reply = old.Reply
+		}
+		for _, flag := range fields[1:] {
+			switch strings.ToLower(flag) {
+			case "modifies":
+				cinfo.Modifies = true
+			case "admin":
+				cinfo.Admin = true
+			case "map":
+				cinfo.Reply = replyMap
+			case "float":
+				cinfo.Reply = replyFloat
+			default:
+				return fmt.Errorf("%s:%d: unknown flag %s", path, lineNum, flag)
+			}
+		}
+		m[cmd] = cinfo
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	commandMap = m
+	return nil
 }
 
 func getCommandInfo(cmd string) (*CommandInfo, bool) {
@@ -0,0 +1,132 @@
+package redis
+
+import (
+	"github.com/fzzy/radix/extra/pubsub"
+	"github.com/fzzy/radix/redis"
+	"github.com/grooveshark/golib/gslog"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/reconnect"
+	"github.com/mediocregopher/hyrax/types"
+)
+
+// The pattern of the keyevent channels redis publishes notifications on. The
+// channel name is the event, the message is the key the event happened to.
+const keyeventPattern = "__keyevent@*__:*"
+
+// Notifier subscribes to redis's keyevent notifications and turns each one into
+// an Action, which is pushed onto EventCh. Redis must have been configured to
+// send keyevent notifications (see the notify-keyspace-events option) for
+// anything to come through.
+type Notifier struct {
+	conntype string
+	addr     string
+	sub      *pubsub.SubClient
+
+	// All keyevent notifications are pushed down this channel. The Command of
+	// each will be the redis event name (e.g. "set", "expired") and the
+	// StorageKey will be the key the event happened to.
+	EventCh chan *types.Action
+
+	closeCh chan struct{}
+}
+
+// NewNotifier connects to redis at the given address and starts reading
+// keyevent notifications off of it
+func NewNotifier(conntype, addr string) (*Notifier, error) {
+	n := Notifier{
+		conntype: conntype,
+		addr:     addr,
+		EventCh:  make(chan *types.Action),
+		closeCh:  make(chan struct{}),
+	}
+	if err := n.subscribe(); err != nil {
+		return nil, err
+	}
+	go n.spin()
+	return &n, nil
+}
+
+func (n *Notifier) subscribe() error {
+	conn, err := redis.Dial(n.conntype, n.addr)
+	if err != nil {
+		gslog.Errorf("connecting to redis at %s: %s", n.addr, err)
+		return err
+	}
+
+	sub := pubsub.NewSubClient(conn)
+	if r := sub.PSubscribe(keyeventPattern); r.Err != nil {
+		conn.Close()
+		return r.Err
+	}
+	n.sub = sub
+	return nil
+}
+
+func (n *Notifier) spin() {
+	for {
+		r := n.sub.Receive()
+		select {
+		case <-n.closeCh:
+			close(n.EventCh)
+			return
+		default:
+		}
+
+		if r.Err != nil {
+			gslog.Errorf("redis notifier at %s: %s", n.addr, r.Err)
+			n.sub.Client.Close()
+			if !n.resurrect() {
+				close(n.EventCh)
+				return
+			}
+			continue
+		}
+		if r.Type != pubsub.MessageReply {
+			continue
+		}
+
+		i := strings.LastIndex(r.Channel, ":")
+		a := &types.Action{
+			Command:    r.Channel[i+1:],
+			StorageKey: r.Message,
+		}
+		select {
+		case n.EventCh <- a:
+		case <-n.closeCh:
+			close(n.EventCh)
+			return
+		}
+	}
+}
+
+// The kind of the reconnect events emitted by Notifier
+const notifyReconnectKind = "redis-notify"
+
+func (n *Notifier) resurrect() bool {
+	reconnect.Emit(notifyReconnectKind, n.addr, reconnect.Reconnecting, 0)
+	b := reconnect.NewForever()
+	for {
+		wait, _ := b.Next()
+		select {
+		case <-n.closeCh:
+			return false
+		case <-time.After(wait):
+		}
+		if err := n.subscribe(); err == nil {
+			reconnect.Emit(notifyReconnectKind, n.addr, reconnect.Connected, 0)
+			return true
+		}
+		reconnect.Emit(
+			notifyReconnectKind, n.addr, reconnect.Reconnecting, b.Attempts(),
+		)
+	}
+}
+
+// Close stops the Notifier from reading any more notifications and closes its
+// connection. EventCh will be closed once it has stopped.
+func (n *Notifier) Close() error {
+	close(n.closeCh)
+	return n.sub.Client.Close()
+}
@@ -1,11 +1,17 @@
 package redis
 
 import (
+	"fmt"
 	"github.com/fzzy/radix/redis"
 	"github.com/grooveshark/golib/gslog"
 	"io"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/mediocregopher/hyrax/server/config"
+	"github.com/mediocregopher/hyrax/server/reconnect"
 	"github.com/mediocregopher/hyrax/server/storage"
 )
 
@@ -40,6 +46,15 @@ type RedisConn struct {
 	conn     *redis.Client
 	cmdCh    chan *storage.CommandBundle
 	closeCh  chan chan error
+
+	// Only set if the primary is found through sentinel, in which case the
+	// new address of the primary is pushed onto switchCh when it fails over
+	sentinel *sentinel
+	switchCh chan string
+
+	// Connections which blocking commands have finished with, kept to be used
+	// by later ones. See BlockingCmd
+	idle chan idleConn
 }
 
 // Returns an unconnected redis connection structure as per the Storage
@@ -49,12 +64,30 @@ func New() storage.Storage {
 }
 
 // Implements Connect for Storage. Connects to redis over tcp and spawns a
-// handler go-routine
+// handler go-routine. If a non-empty []string of sentinel addresses is given as
+// the extra argument then addr is the master name of the primary to find
+// through them, rather than its address.
 func (r *RedisConn) Connect(cmdCh chan *storage.CommandBundle,
-	conntype, addr string, _ ...interface{}) error {
+	conntype, addr string, extra ...interface{}) error {
+
+	if len(extra) > 0 {
+		if saddrs, _ := extra[0].([]string); len(saddrs) > 0 {
+			s, err := getSentinel(addr, saddrs)
+			if err != nil {
+				gslog.Errorf("finding redis primary %s: %s", addr, err)
+				return err
+			}
+			r.sentinel = s
+			r.switchCh = make(chan string, 1)
+			s.watch(r.switchCh)
+			addr = s.addr()
+		}
+	}
+
 	conn, err := redis.Dial(conntype, addr)
 	if err != nil {
 		gslog.Errorf("connecting to redis at %s: %s", addr, err)
+		r.releaseSentinel()
 		return err
 	}
 
@@ -63,28 +96,51 @@ func (r *RedisConn) Connect(cmdCh chan *storage.CommandBundle,
 	r.conn = conn
 	r.cmdCh = cmdCh
 	r.closeCh = make(chan chan error)
+	r.idle = make(chan idleConn, config.StorageBlockingMax)
 	go r.spin()
 	return nil
 }
 
+func (r *RedisConn) releaseSentinel() {
+	if r.sentinel != nil {
+		r.sentinel.unwatch(r.switchCh)
+		r.sentinel.release()
+	}
+}
+
 func (r *RedisConn) spin() {
 spinloop:
 	for {
 		select {
 
 		case retCh := <-r.closeCh:
+			r.releaseSentinel()
+			r.closeIdle()
 			retCh <- r.conn.Close()
 			break spinloop
 
+		case addr := <-r.switchCh:
+			if addr == r.addr {
+				continue
+			}
+			r.conn.Close()
+			if !r.resurrect() {
+				break spinloop
+			}
+
 		case cmdb := <-r.cmdCh:
 			rawret, err := r.cmd(cmdb.Cmd)
+			lost := r.connLost(err)
+			if lost && r.sentinel != nil {
+				err = storage.RetryErr
+			}
 			ret := storage.CommandRet{rawret, err}
 			select {
 			case cmdb.RetCh <- &ret:
 			case <-time.After(10 * time.Second):
 				gslog.Errorf("RedisConn timedout replying to cmd %v", cmdb.Cmd)
 			}
-			if err == io.EOF && !r.resurrect() {
+			if lost && !r.resurrect() {
 				break spinloop
 			}
 		}
@@ -93,28 +149,87 @@ spinloop:
 	close(r.closeCh)
 }
 
+// connLost returns whether the error from a command means the connection needs
+// to be remade. When using sentinel a primary which has been demoted to a
+// replica also counts, since the connection needs to be made to the new primary
+func (r *RedisConn) connLost(err error) bool {
+	if err == nil {
+		return false
+	} else if err == io.EOF {
+		return true
+	} else if r.sentinel == nil {
+		return false
+	} else if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return strings.HasPrefix(err.Error(), "READONLY")
+}
+
+// The kind of the reconnect events emitted by RedisConn
+const reconnectKind = "redis"
+
+// resurrect reconnects to redis, backing off between attempts. It never gives
+// up, since nothing can be done without the datastore. When using sentinel the
+// connection is made to wherever the primary currently is, and any commands
+// which come in while reconnecting are failed with RetryErr rather than being
+// left to wait.
 func (r *RedisConn) resurrect() bool {
-	connCh := make(chan *redis.Client)
+	reconnect.Emit(reconnectKind, r.addr, reconnect.Reconnecting, 0)
+	type newConn struct {
+		conn *redis.Client
+		addr string
+	}
+	connCh := make(chan newConn)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
 	go func() {
+		b := reconnect.NewForever()
 		for {
-			time.Sleep(2 * time.Second)
-			conn, err := redis.Dial(r.conntype, r.addr)
+			wait, _ := b.Next()
+			time.Sleep(wait)
+			addr := r.addr
+			if r.sentinel != nil {
+				addr = r.sentinel.addr()
+			}
+			conn, err := redis.Dial(r.conntype, addr)
 			if err != nil {
-				gslog.Errorf("connecting to redis at %s: %s", r.addr, err)
+				gslog.Errorf("connecting to redis at %s: %s", addr, err)
+				reconnect.Emit(
+					reconnectKind, addr, reconnect.Reconnecting, b.Attempts(),
+				)
 				continue
 			}
-			connCh <- conn
+			select {
+			case connCh <- newConn{conn, addr}:
+			case <-stopCh:
+				conn.Close()
+			}
 			return
 		}
 	}()
 
+	var cmdCh chan *storage.CommandBundle
+	if r.sentinel != nil {
+		cmdCh = r.cmdCh
+	}
 	for {
 		select {
 		case retCh := <-r.closeCh:
+			r.releaseSentinel()
+			r.closeIdle()
 			retCh <- r.conn.Close()
 			return false
-		case conn := <-connCh:
-			r.conn = conn
+		case cmdb := <-cmdCh:
+			ret := storage.CommandRet{Err: storage.RetryErr}
+			select {
+			case cmdb.RetCh <- &ret:
+			case <-time.After(10 * time.Second):
+				gslog.Errorf("RedisConn timedout replying to cmd %v", cmdb.Cmd)
+			}
+		case nc := <-connCh:
+			r.conn = nc.conn
+			r.addr = nc.addr
+			reconnect.Emit(reconnectKind, r.addr, reconnect.Connected, 0)
 			return true
 		}
 	}
@@ -124,16 +239,26 @@ func (r *RedisConn) cmd(cmd storage.Command) (interface{}, error) {
 	gslog.Debugf("Redis cmd: %v, %v", cmd.Cmd(), cmd.Args())
 	reply := r.conn.Cmd(cmd.Cmd(), cmd.Args()...)
 	dreply, err := decodeReply(reply)
+	if err == nil {
+		dreply = shapeReply(cmd.Cmd(), dreply)
+	}
 	gslog.Debugf("Redis reply: %v, %v", dreply, err)
 	return dreply, err
 }
 
-// Decodes a reply into a generic interface object, or an error
+// Decodes a reply into a generic interface object, or an error. Multi-bulk
+// replies are decoded into lists, recursively. Bulk replies are decoded into
+// strings holding their bytes as is, so binary values come through intact, and
+// status replies into Status so they can be told apart from them
 func decodeReply(r *redis.Reply) (interface{}, error) {
 	switch r.Type {
 	case redis.StatusReply:
 		gslog.Debugf("Redis status reply")
-		return r.Str()
+		s, err := r.Str()
+		if err != nil {
+			return nil, err
+		}
+		return storage.Status(s), nil
 
 	case redis.ErrorReply:
 		gslog.Debugf("Redis error reply")
@@ -153,12 +278,52 @@ func decodeReply(r *redis.Reply) (interface{}, error) {
 
 	case redis.MultiReply:
 		gslog.Debugf("Redis multibulk reply")
-		return r.List()
+		l := make([]interface{}, len(r.Elems))
+		for i := range r.Elems {
+			elem, err := decodeReply(r.Elems[i])
+			if err != nil {
+				return nil, err
+			}
+			l[i] = elem
+		}
+		return l, nil
 	}
 
 	return nil, nil
 }
 
+// shapeReply turns a decoded reply into the shape replies to the command are
+// described as having in its CommandInfo
+func shapeReply(cmd string, r interface{}) interface{} {
+	cinfo, ok := getCommandInfo(cmd)
+	if !ok {
+		return r
+	}
+
+	switch cinfo.Reply {
+	case replyMap:
+		l, ok := r.([]interface{})
+		if !ok || len(l)%2 != 0 {
+			return r
+		}
+		m := make(map[string]interface{}, len(l)/2)
+		for i := 0; i < len(l); i += 2 {
+			m[fmt.Sprint(l[i])] = l[i+1]
+		}
+		return m
+
+	case replyFloat:
+		s, ok := r.(string)
+		if !ok || !config.StorageTypedReplies {
+			return r
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return storage.FloatReply(f)
+		}
+	}
+	return r
+}
+
 // Implements NewCommand for Storage
 func (_ *RedisConn) NewCommand(cmd string, args ...interface{}) storage.Command {
 	return NewRedisCommand(cmd, args...)
@@ -176,10 +341,11 @@ func (_ *RedisConn) CommandModifies(cmd string) bool {
 	return ok && cinfo.Modifies
 }
 
-// Implements CommandIsAdmin for Storage. Redis has no administrative commands
-// which are allowed so this is always false
-func (_ *RedisConn) CommandIsAdmin(_ string) bool {
-	return false
+// Implements CommandIsAdmin for Storage. Only commands marked admin by
+// LoadCommands are
+func (_ *RedisConn) CommandIsAdmin(cmd string) bool {
+	cinfo, ok := getCommandInfo(cmd)
+	return ok && cinfo.Admin
 }
 
 // Implements Close for Storage
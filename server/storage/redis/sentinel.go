@@ -0,0 +1,271 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fzzy/radix/extra/pubsub"
+	"github.com/fzzy/radix/redis"
+	"github.com/grooveshark/golib/gslog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/hyrax/server/reconnect"
+)
+
+// The channel sentinels announce failovers on. Messages on it look like
+// "<name> <old ip> <old port> <new ip> <new port>"
+const switchMasterChannel = "+switch-master"
+
+// The kind of the reconnect events emitted by sentinel
+const sentinelReconnectKind = "redis-sentinel"
+
+var noSentinels = errors.New("no sentinel addresses given")
+
+// A sentinel keeps track of the address of the current primary for a single
+// master name, by asking a set of redis sentinels and then listening to them for
+// failovers. It's shared by all connections to that primary.
+type sentinel struct {
+	name  string
+	addrs []string
+	refs  int
+
+	// Protected by the package's sentinelsLock
+	watchers map[chan string]bool
+
+	sync.RWMutex
+	masterAddr string
+	sub        *pubsub.SubClient
+	closeCh    chan struct{}
+}
+
+var sentinels = map[string]*sentinel{}
+var sentinelsLock sync.Mutex
+
+// getSentinel returns the sentinel for the given master name, creating it if
+// there isn't one already. The address of the primary is looked up before this
+// returns. release must be called once the sentinel isn't needed anymore
+func getSentinel(name string, addrs []string) (*sentinel, error) {
+	sentinelsLock.Lock()
+	defer sentinelsLock.Unlock()
+	if s, ok := sentinels[name]; ok {
+		s.refs++
+		return s, nil
+	}
+
+	if len(addrs) == 0 {
+		return nil, noSentinels
+	}
+	s := &sentinel{
+		name:     name,
+		addrs:    addrs,
+		refs:     1,
+		watchers: map[chan string]bool{},
+		closeCh:  make(chan struct{}),
+	}
+	masterAddr, err := s.queryMaster()
+	if err != nil {
+		return nil, err
+	}
+	s.masterAddr = masterAddr
+	if err := s.subscribe(); err != nil {
+		return nil, err
+	}
+
+	sentinels[name] = s
+	go s.spin()
+	return s, nil
+}
+
+// release is called once a user of the sentinel is done with it. Once all of
+// them are it stops listening to the sentinels
+func (s *sentinel) release() {
+	sentinelsLock.Lock()
+	defer sentinelsLock.Unlock()
+	if s.refs--; s.refs > 0 {
+		return
+	}
+	delete(sentinels, s.name)
+	close(s.closeCh)
+	s.RLock()
+	s.sub.Client.Close()
+	s.RUnlock()
+}
+
+// watch causes the new address of the primary to be pushed onto the given
+// channel whenever it changes. If the address changes again before the last one
+// is read off the channel only the latest will be there, so the channel should
+// be buffered.
+func (s *sentinel) watch(ch chan string) {
+	sentinelsLock.Lock()
+	defer sentinelsLock.Unlock()
+	s.watchers[ch] = true
+}
+
+// unwatch undoes watch
+func (s *sentinel) unwatch(ch chan string) {
+	sentinelsLock.Lock()
+	defer sentinelsLock.Unlock()
+	delete(s.watchers, ch)
+}
+
+// addr returns the current known address of the primary
+func (s *sentinel) addr() string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.masterAddr
+}
+
+// setAddr records the new address of the primary and tells the watchers, if
+// the address has actually changed
+func (s *sentinel) setAddr(addr string) {
+	s.Lock()
+	changed := s.masterAddr != addr
+	s.masterAddr = addr
+	s.Unlock()
+	if !changed {
+		return
+	}
+
+	gslog.Infof("redis primary %s is now at %s", s.name, addr)
+	sentinelsLock.Lock()
+	defer sentinelsLock.Unlock()
+	for ch := range s.watchers {
+		for {
+			select {
+			case ch <- addr:
+			case <-ch:
+				continue
+			}
+			break
+		}
+	}
+}
+
+// queryMaster asks each sentinel in turn for the address of the primary,
+// returning the first answer
+func (s *sentinel) queryMaster() (string, error) {
+	var err error
+	for _, saddr := range s.addrs {
+		var addr string
+		if addr, err = queryMasterAt(saddr, s.name); err == nil {
+			return addr, nil
+		}
+		gslog.Errorf("asking sentinel %s for %s: %s", saddr, s.name, err)
+	}
+	return "", err
+}
+
+func queryMasterAt(saddr, name string) (string, error) {
+	conn, err := redis.Dial("tcp", saddr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	r := conn.Cmd("SENTINEL", "get-master-addr-by-name", name)
+	if r.Type == redis.NilReply {
+		return "", fmt.Errorf("sentinel doesn't know master %s", name)
+	}
+	l, err := r.List()
+	if err != nil {
+		return "", err
+	} else if len(l) != 2 {
+		return "", fmt.Errorf("malformed sentinel reply: %v", l)
+	}
+	return net.JoinHostPort(l[0], l[1]), nil
+}
+
+// subscribe subscribes to failover announcements on the first sentinel which
+// can be connected to
+func (s *sentinel) subscribe() error {
+	var err error
+	for _, saddr := range s.addrs {
+		var conn *redis.Client
+		if conn, err = redis.Dial("tcp", saddr); err != nil {
+			gslog.Errorf("connecting to sentinel at %s: %s", saddr, err)
+			continue
+		}
+		sub := pubsub.NewSubClient(conn)
+		if r := sub.Subscribe(switchMasterChannel); r.Err != nil {
+			err = r.Err
+			conn.Close()
+			continue
+		}
+		s.Lock()
+		s.sub = sub
+		s.Unlock()
+		return nil
+	}
+	return err
+}
+
+func (s *sentinel) spin() {
+	for {
+		s.RLock()
+		sub := s.sub
+		s.RUnlock()
+
+		r := sub.Receive()
+		select {
+		case <-s.closeCh:
+			return
+		default:
+		}
+
+		if r.Err != nil {
+			gslog.Errorf("redis sentinel for %s: %s", s.name, r.Err)
+			sub.Client.Close()
+			if !s.resurrect() {
+				return
+			}
+			continue
+		}
+		if r.Type != pubsub.MessageReply {
+			continue
+		}
+
+		parts := strings.Fields(r.Message)
+		if len(parts) != 5 || parts[0] != s.name {
+			continue
+		}
+		s.setAddr(net.JoinHostPort(parts[3], parts[4]))
+	}
+}
+
+// resurrect resubscribes to the sentinels, backing off between attempts. Since
+// a failover could have been missed while disconnected the primary's address is
+// looked up again as well
+func (s *sentinel) resurrect() bool {
+	reconnect.Emit(sentinelReconnectKind, s.name, reconnect.Reconnecting, 0)
+	b := reconnect.NewForever()
+	for {
+		wait, _ := b.Next()
+		select {
+		case <-s.closeCh:
+			return false
+		case <-time.After(wait):
+		}
+		if err := s.subscribe(); err == nil {
+			select {
+			case <-s.closeCh:
+				s.RLock()
+				s.sub.Client.Close()
+				s.RUnlock()
+				return false
+			default:
+			}
+			if addr, err := s.queryMaster(); err == nil {
+				s.setAddr(addr)
+			}
+			reconnect.Emit(
+				sentinelReconnectKind, s.name, reconnect.Connected, 0,
+			)
+			return true
+		}
+		reconnect.Emit(
+			sentinelReconnectKind, s.name, reconnect.Reconnecting, b.Attempts(),
+		)
+	}
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"sync"
+)
+
+// ScriptFunc is a go implementation of a script, for backends which can't run
+// scripts themselves. call runs a single command against the backend the same
+// way the script would, and keys and args are what the script was given. A
+// backend runs the whole function atomically.
+type ScriptFunc func(
+	call func(cmd string, args ...interface{}) (interface{}, error),
+	keys []string,
+	args []interface{}) (interface{}, error)
+
+var scriptFuncs = map[string]ScriptFunc{}
+var scriptFuncsLock sync.RWMutex
+
+// RegisterScriptFunc registers the go implementation of the given script
+func RegisterScriptFunc(script string, fn ScriptFunc) {
+	scriptFuncsLock.Lock()
+	scriptFuncs[script] = fn
+	scriptFuncsLock.Unlock()
+}
+
+// GetScriptFunc returns the go implementation of the given script, if one has
+// been registered
+func GetScriptFunc(script string) (ScriptFunc, bool) {
+	scriptFuncsLock.RLock()
+	defer scriptFuncsLock.RUnlock()
+	fn, ok := scriptFuncs[script]
+	return fn, ok
+}
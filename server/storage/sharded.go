@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"errors"
+	"hash/crc32"
+	"sync/atomic"
+)
+
+var noShards = errors.New("no shard addresses given")
+var wrongReplicaCount = errors.New("number of replica addresses doesn't match number of shards")
+
+// A sharded storage unit is a set of storage units, one per shard, which
+// commands are spread across by the key they act on. Each key always lives on
+// the same shard, so long as the list of shard addresses doesn't change. Each
+// shard can also have replicas which commands that only read can be sent to.
+type ShardedStorageUnit struct {
+	shards   []*StorageUnit
+	replicas [][]*StorageUnit
+
+	// Incremented on every read, used to spread reads across replicas
+	reads uint32
+}
+
+// NewShardedStorageUnit creates a StorageUnit for each of the given addresses,
+// each with size connections made by calling newFn. If any of the StorageUnits
+// can't be created all the previous ones will be Close'd and the error will be
+// returned.
+func NewShardedStorageUnit(
+	newFn func() Storage,
+	size int,
+	conntype string,
+	addrs []string,
+	extra ...interface{}) (*ShardedStorageUnit, error) {
+
+	if len(addrs) == 0 {
+		return nil, noShards
+	}
+
+	ssu := ShardedStorageUnit{
+		shards:   make([]*StorageUnit, 0, len(addrs)),
+		replicas: make([][]*StorageUnit, len(addrs)),
+	}
+	for _, addr := range addrs {
+		su, err := newSizedStorageUnit(newFn, size, conntype, addr, extra...)
+		if err != nil {
+			ssu.Close()
+			return nil, err
+		}
+		ssu.shards = append(ssu.shards, su)
+	}
+	return &ssu, nil
+}
+
+func newSizedStorageUnit(
+	newFn func() Storage,
+	size int,
+	conntype, addr string,
+	extra ...interface{}) (*StorageUnit, error) {
+
+	sucs := make([]Storage, size)
+	for i := range sucs {
+		sucs[i] = newFn()
+	}
+	return NewStorageUnit(sucs, conntype, addr, extra...)
+}
+
+// AddReplicas creates a StorageUnit for a replica of each shard, with the
+// replica addresses given in the same order as the shards' addresses. It can be
+// called multiple times to give each shard multiple replicas. If any of the
+// StorageUnits can't be created all the ones created by this call are Close'd
+// and the error is returned. This must be called before the ShardedStorageUnit
+// is used.
+func (ssu *ShardedStorageUnit) AddReplicas(
+	newFn func() Storage,
+	size int,
+	conntype string,
+	addrs []string,
+	extra ...interface{}) error {
+
+	if len(addrs) != len(ssu.shards) {
+		return wrongReplicaCount
+	}
+
+	sus := make([]*StorageUnit, 0, len(addrs))
+	for _, addr := range addrs {
+		su, err := newSizedStorageUnit(newFn, size, conntype, addr, extra...)
+		if err != nil {
+			for _, su := range sus {
+				su.Close()
+			}
+			return err
+		}
+		sus = append(sus, su)
+	}
+
+	for i, su := range sus {
+		ssu.replicas[i] = append(ssu.replicas[i], su)
+	}
+	return nil
+}
+
+// ShardFor returns the index of the shard the given key lives on
+func (ssu *ShardedStorageUnit) ShardFor(key string) int {
+	return int(crc32.ChecksumIEEE([]byte(key)) % uint32(len(ssu.shards)))
+}
+
+// Shards returns the StorageUnit for each shard, in the order their addresses
+// were given
+func (ssu *ShardedStorageUnit) Shards() []*StorageUnit {
+	return ssu.shards
+}
+
+// Replicas returns the StorageUnits for each of the given shard's replicas
+func (ssu *ShardedStorageUnit) Replicas(shard int) []*StorageUnit {
+	return ssu.replicas[shard]
+}
+
+// Cmd performs the command on the primary of the shard the given key lives on
+func (ssu *ShardedStorageUnit) Cmd(key string, cmd Command) (interface{}, error) {
+	return ssu.shards[ssu.ShardFor(key)].Cmd(cmd)
+}
+
+// ReadCmd performs the command on one of the replicas of the shard the given
+// key lives on, or on its primary if it has no replicas. The command must not
+// modify anything. Replicas are picked in turn.
+func (ssu *ShardedStorageUnit) ReadCmd(key string, cmd Command) (interface{}, error) {
+	i := ssu.ShardFor(key)
+	replicas := ssu.replicas[i]
+	if len(replicas) == 0 {
+		return ssu.shards[i].Cmd(cmd)
+	}
+	n := atomic.AddUint32(&ssu.reads, 1)
+	return replicas[n%uint32(len(replicas))].Cmd(cmd)
+}
+
+// BlockingCmd performs the blocking command on a dedicated connection to the
+// primary of the shard the given key lives on. If cancelCh is closed before the
+// command completes it's abandoned and CancelledErr is returned
+func (ssu *ShardedStorageUnit) BlockingCmd(
+	key string, cmd Command, cancelCh <-chan struct{}) (interface{}, error) {
+	return ssu.shards[ssu.ShardFor(key)].BlockingCmd(cmd, cancelCh)
+}
+
+// Close calls Close on every shard's and replica's StorageUnit. The last
+// non-nil error to be returned by any of them is returned, or nil if none of
+// them returned an error.
+func (ssu *ShardedStorageUnit) Close() error {
+	var retErr error
+	for i, su := range ssu.shards {
+		if err := su.Close(); err != nil {
+			retErr = err
+		}
+		for _, rsu := range ssu.replicas[i] {
+			if err := rsu.Close(); err != nil {
+				retErr = err
+			}
+		}
+	}
+	return retErr
+}
+
+// Returns a new Command instance based on the given command and arguments
+func (ssu *ShardedStorageUnit) NewCommand(cmd string, args ...interface{}) Command {
+	return ssu.shards[0].NewCommand(cmd, args...)
+}
+
+// Returns whether or not a command is allowed to be run at all on the datastore
+func (ssu *ShardedStorageUnit) CommandAllowed(cmd string) bool {
+	return ssu.shards[0].CommandAllowed(cmd)
+}
+
+// Returns whether or not a command modifies state on the datastore
+func (ssu *ShardedStorageUnit) CommandModifies(cmd string) bool {
+	return ssu.shards[0].CommandModifies(cmd)
+}
+
+func (ssu *ShardedStorageUnit) CommandIsAdmin(cmd string) bool {
+	return ssu.shards[0].CommandIsAdmin(cmd)
+}
+
+// Returns whether or not a command blocks, and so must be run with BlockingCmd
+func (ssu *ShardedStorageUnit) CommandBlocks(cmd string) bool {
+	return ssu.shards[0].CommandBlocks(cmd)
+}
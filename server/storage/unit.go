@@ -4,8 +4,22 @@ import (
 	"errors"
 	"github.com/grooveshark/golib/gslog"
 	"time"
+
+	"github.com/mediocregopher/hyrax/server/config"
 )
 
+// RetryErr is returned by a Storage for commands which failed because the
+// datastore was briefly unavailable (e.g. it was failing over), and which can
+// be safely retried
+var RetryErr = errors.New("RETRY datastore unavailable, try again")
+
+// CancelledErr is returned by a blocking command which was abandoned before it
+// completed
+var CancelledErr = errors.New("command cancelled")
+
+var notBlocker = errors.New("blocking commands not supported")
+var tooManyBlocking = errors.New("too many blocking commands waiting")
+
 // CommandRet is returned from a Command in the RetCh. It's really just a tuple
 // around the return value and an error
 type CommandRet struct {
@@ -73,6 +87,24 @@ type Storage interface {
 	Close() error
 }
 
+// Blocker is implemented by Storages which support commands that block until
+// something happens in the datastore (like blpop). Since these would tie up one
+// of a StorageUnit's connections they are run on dedicated connections instead.
+type Blocker interface {
+
+	// Returns whether or not a command blocks, and so must be run through
+	// BlockingCmd. This method should not actually affect anything about the
+	// Storage connection.
+	CommandBlocks(string) bool
+
+	// BlockingCmd runs the blocking command on a connection of its own to the
+	// given address, which nothing else uses while the command runs. If cancelCh is
+	// closed before the command completes the connection is closed right away
+	// and CancelledErr is returned.
+	BlockingCmd(conntype, addr string, cmd Command,
+		cancelCh <-chan struct{}) (interface{}, error)
+}
+
 // A storage unit is a pool of storage unit conns which can be opened and closed
 // as a single group. It also multiplexes calls across the connections.
 type StorageUnit struct {
@@ -80,6 +112,9 @@ type StorageUnit struct {
 	conns          []Storage
 	cmdCh          chan *CommandBundle
 	closeCh        chan chan error
+
+	// Holds a value for every blocking command currently running
+	blockingCh chan struct{}
 }
 
 // NewStorageUnit takes in a slice of zero'd Storages, a connection
@@ -98,6 +133,8 @@ func NewStorageUnit(
 		conns:    make([]Storage, 0, len(sucs)),
 		cmdCh:    make(chan *CommandBundle),
 		closeCh:  make(chan chan error),
+
+		blockingCh: make(chan struct{}, config.StorageBlockingMax),
 	}
 
 	for _, suc := range sucs {
@@ -178,6 +215,28 @@ func (su *StorageUnit) Cmd(cmd Command) (interface{}, error) {
 	}
 }
 
+// BlockingCmd performs a blocking command on a dedicated connection, rather
+// than on one of the pooled ones. If cancelCh is closed before the command
+// completes it's abandoned and CancelledErr is returned
+func (su *StorageUnit) BlockingCmd(
+	cmd Command, cancelCh <-chan struct{}) (interface{}, error) {
+
+	b, ok := su.conns[0].(Blocker)
+	if !ok {
+		return nil, notBlocker
+	}
+
+	select {
+	case su.blockingCh <- struct{}{}:
+	default:
+		gslog.Warnf("too many blocking commands on %s:%s", su.ConnType, su.Addr)
+		return nil, tooManyBlocking
+	}
+	defer func() { <-su.blockingCh }()
+
+	return b.BlockingCmd(su.ConnType, su.Addr, cmd, cancelCh)
+}
+
 // Returns a new Command instance based on the given command and arguments
 func (su *StorageUnit) NewCommand(cmd string, args ...interface{}) Command {
 	return su.conns[0].NewCommand(cmd, args...)
@@ -196,3 +255,9 @@ func (su *StorageUnit) CommandModifies(cmd string) bool {
 func (su *StorageUnit) CommandIsAdmin(cmd string) bool {
 	return su.conns[0].CommandIsAdmin(cmd)
 }
+
+// Returns whether or not a command blocks, and so must be run with BlockingCmd
+func (su *StorageUnit) CommandBlocks(cmd string) bool {
+	b, ok := su.conns[0].(Blocker)
+	return ok && b.CommandBlocks(cmd)
+}
@@ -18,4 +18,10 @@ type Client interface {
 	// ClosingCh returns a channel which will have close() called on it when the
 	// connection is closed
 	ClosingCh() <-chan struct{}
+
+	// GoneCh returns a channel which will have close() called on it as soon as
+	// the client is known to have disconnected, before any cleanup is done for
+	// it and before ClosingCh is closed. Nothing should be sent to the client
+	// once it's closed.
+	GoneCh() <-chan struct{}
 }
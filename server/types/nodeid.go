@@ -0,0 +1,19 @@
+package types
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/grooveshark/golib/gslog"
+)
+
+// NodeId is a random identifier generated for this node every time it starts
+// up. It's used to tell which node in the cluster something originated on
+var NodeId = newNodeId()
+
+func newNodeId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		gslog.Fatal(err.Error())
+	}
+	return hex.EncodeToString(b)
+}
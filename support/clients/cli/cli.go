@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"github.com/mediocregopher/flagconfig"
+	"os"
+	"sort"
 
 	"github.com/mediocregopher/hyrax/client"
 	"github.com/mediocregopher/hyrax/types"
@@ -12,14 +14,23 @@ func printError(err error) {
 	fmt.Println("ERR:", err)
 }
 
+// topology subcommand: calls adist on the node and prints the result as a tree
+const topologyCmd = "topology"
+
 func main() {
+	topology := false
+	if len(os.Args) > 1 && os.Args[1] == topologyCmd {
+		topology = true
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	fc := flagconfig.New("hyrax-cli")
 	fc.DisallowConfig()
 	fc.StrParam("format", "protocol format to use", "json")
 	fc.StrParam("conn-type", "connection type to use", "tcp")
 	fc.StrParam("addr", "address or socket location to connect to", "127.0.0.1:2379")
 	fc.FlagParam("hold", "hold onto the connection even after the result has been returned, and output push messages as they come in", false)
-	fc.RequiredStrParam("cmd", "cmd to execute")
+	fc.StrParam("cmd", "cmd to execute (required unless using the \"topology\" subcommand)", "")
 	fc.StrParam("key", "key the command is executed against, if any", "")
 	fc.StrParams("arg", "argument to command")
 	fc.StrParam("id", "id of the client issuing command, if any", "")
@@ -30,6 +41,11 @@ func main() {
 		return
 	}
 
+	if !topology && fc.GetStr("cmd") == "" {
+		fmt.Println("cmd is required")
+		return
+	}
+
 	format := fc.GetStr("format")
 	conntype := fc.GetStr("conn-type")
 	addr := fc.GetStr("addr")
@@ -51,6 +67,12 @@ func main() {
 	id := fc.GetStr("id")
 	secretKey := fc.GetStr("secret-key")
 
+	if topology {
+		printTopology(c, secretKey)
+		c.Close()
+		return
+	}
+
 	argsStrs := fc.GetStrs("arg")
 	args := make([]interface{}, len(argsStrs))
 	for i := range argsStrs {
@@ -81,3 +103,60 @@ func main() {
 
 	c.Close()
 }
+
+// The sections of the adist return, in the order they're printed
+var distSections = []struct{ field, title string }{
+	{"pullfromglobal", "pulling global events from"},
+	{"pullfromlocal", "pulling local events from"},
+	{"pushto", "pushing local events to"},
+}
+
+func printTopology(c client.Client, secretKey string) {
+	ret, err := c.Cmd(client.CreateAction("adist", "", "", secretKey))
+	if err != nil {
+		printError(err)
+		return
+	}
+	dist, ok := ret.(map[string]interface{})
+	if !ok {
+		printError(fmt.Errorf("unexpected adist return: %v", ret))
+		return
+	}
+	now, _ := dist["time"].(float64)
+
+	fmt.Printf("node %v (%v, %v)\n", dist["node"], dist["endpoint"], dist["topology"])
+	for i, section := range distSections {
+		branch, indent := "├── ", "│   "
+		if i == len(distSections)-1 {
+			branch, indent = "└── ", "    "
+		}
+		fmt.Println(branch + section.title)
+
+		conns, _ := dist[section.field].([]interface{})
+		lines := make([]string, 0, len(conns))
+		for _, connI := range conns {
+			conn, _ := connI.(map[string]interface{})
+			last := "never"
+			if lastCmd, _ := conn["lastcmd"].(float64); lastCmd > 0 {
+				last = fmt.Sprintf("%ds ago", int64(now-lastCmd))
+			}
+			lines = append(lines, fmt.Sprintf(
+				"%v %v, last cmd %s, %v reconnects, %v events",
+				conn["endpoint"],
+				conn["state"],
+				last,
+				conn["reconnects"],
+				conn["events"],
+			))
+		}
+		sort.Strings(lines)
+
+		for j, line := range lines {
+			if j == len(lines)-1 {
+				fmt.Println(indent + "└── " + line)
+			} else {
+				fmt.Println(indent + "├── " + line)
+			}
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/mediocregopher/hyrax/client"
+	"github.com/mediocregopher/hyrax/types"
+)
+
+// The ways the nodes of a cluster can be connected to each other
+const (
+	// Every node is given every other node as a push-to-endpoint
+	layoutMesh = "mesh"
+
+	// Nodes are in mesh mode, but only find each other by gossiping with the
+	// first node
+	layoutGossip = "gossip"
+
+	// The first node is the root of a tree, every other node pushes to it and
+	// pulls from it
+	layoutTree = "tree"
+)
+
+// How long to wait for a started cluster to have connected itself up
+const settleTimeout = 30 * time.Second
+
+type node struct {
+	le     *types.ListenEndpoint
+	cmd    *exec.Cmd
+	cl     client.Client
+	pushCh chan *types.Action
+}
+
+type cluster struct {
+	nodes []*node
+}
+
+// nodeArgs returns the arguments to start the i'th node of the cluster with,
+// besides the storage ones
+func (c *cluster) nodeArgs(i int, layout string) ([]string, error) {
+	n := c.nodes[i]
+	root := c.nodes[0]
+	args := []string{
+		"--listen-endpoint=" + n.le.String(),
+		"--my-endpoint=" + n.le.String(),
+		"--log-level=warn",
+	}
+
+	switch layout {
+	case layoutMesh:
+		args = append(args, "--topology=mesh")
+		for _, peer := range c.nodes {
+			args = append(args, "--push-to-endpoint="+peer.le.String())
+		}
+	case layoutGossip:
+		args = append(args,
+			"--topology=mesh",
+			"--push-to-endpoint="+n.le.String(),
+		)
+		if i > 0 {
+			args = append(args, "--gossip-seed="+root.le.String())
+		} else {
+			args = append(args, "--gossip")
+		}
+	case layoutTree:
+		args = append(args,
+			"--topology=tree",
+			"--push-to-endpoint="+root.le.String(),
+		)
+		if i > 0 {
+			args = append(args, "--pull-from-endpoint="+root.le.String())
+		}
+	default:
+		return nil, fmt.Errorf("unknown layout: %s", layout)
+	}
+	return args, nil
+}
+
+// startCluster starts a node of the given hyrax-server binary on each of the
+// given ports, connected to each other according to the layout. Each node is
+// given storageArgs as well. Once all the nodes are started a client is
+// connected to each which calls mglobal for mpub events, and the cluster is
+// waited on until events get everywhere.
+func startCluster(
+	server string,
+	storageArgs []string,
+	ports []int,
+	layout string) (*cluster, error) {
+
+	c := &cluster{nodes: make([]*node, len(ports))}
+	for i, port := range ports {
+		addr := "127.0.0.1:" + strconv.Itoa(port)
+		c.nodes[i] = &node{le: types.NewListenEndpoint("tcp", "json", addr)}
+	}
+
+	for i, n := range c.nodes {
+		args, err := c.nodeArgs(i, layout)
+		if err != nil {
+			return nil, err
+		}
+		args = append(append([]string{}, storageArgs...), args...)
+		n.cmd = exec.Command(server, args...)
+		n.cmd.Stdout = os.Stdout
+		n.cmd.Stderr = os.Stderr
+		if err := n.cmd.Start(); err != nil {
+			c.kill()
+			return nil, err
+		}
+	}
+
+	for _, n := range c.nodes {
+		if err := n.connect(); err != nil {
+			c.kill()
+			return nil, err
+		}
+	}
+
+	if err := c.settle(); err != nil {
+		c.kill()
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect connects a client to the node and subscribes it to mpub events,
+// retrying while the node starts up
+func (n *node) connect() error {
+	n.pushCh = make(chan *types.Action, 1024)
+	var err error
+	for start := time.Now(); time.Since(start) < settleTimeout; {
+		if n.cl, err = client.NewClient(n.le, n.pushCh); err != nil {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		mglobal := client.CreateAction("mglobal", "", "", "", "cmds", "mpub")
+		_, err = n.cl.Cmd(mglobal)
+		return err
+	}
+	return err
+}
+
+// settle publishes a probe event on every node until every node has seen a
+// probe from every other one, meaning all the connections between them are
+// made, and then throws away everything the nodes were pushed
+func (c *cluster) settle() error {
+	seen := make([]map[string]bool, len(c.nodes))
+	for i := range seen {
+		seen[i] = map[string]bool{}
+	}
+
+	for start := time.Now(); time.Since(start) < settleTimeout; {
+		for i, n := range c.nodes {
+			key := "meshtest:probe:" + strconv.Itoa(i)
+			if _, err := n.cl.Cmd(client.CreateAction("mpub", key, "", "")); err != nil {
+				return err
+			}
+		}
+		time.Sleep(500 * time.Millisecond)
+
+		done := true
+		for i, n := range c.nodes {
+			for _, a := range n.drain() {
+				seen[i][a.StorageKey] = true
+			}
+			done = done && len(seen[i]) == len(c.nodes)
+		}
+		if done {
+			// Probes from the last round may still be on their way
+			time.Sleep(time.Second)
+			for _, n := range c.nodes {
+				n.drain()
+			}
+			return nil
+		}
+	}
+	return errors.New("cluster didn't connect up in time")
+}
+
+// drain returns all the events which have been pushed to the node's client so
+// far
+func (n *node) drain() []*types.Action {
+	var as []*types.Action
+	for {
+		select {
+		case a := <-n.pushCh:
+			as = append(as, a)
+		default:
+			return as
+		}
+	}
+}
+
+// check publishes numEvents events on every node and checks that each is seen
+// exactly once by the client on every node. The returned error lists every
+// event which wasn't
+func (c *cluster) check(numEvents int) error {
+	for i, n := range c.nodes {
+		for j := 0; j < numEvents; j++ {
+			key := "meshtest:" + strconv.Itoa(i) + ":" + strconv.Itoa(j)
+			if _, err := n.cl.Cmd(client.CreateAction("mpub", key, "", "")); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Wait for everything to arrive, and a bit longer to catch duplicates
+	time.Sleep(2 * time.Second)
+
+	msg := ""
+	for i, n := range c.nodes {
+		seen := map[string]int{}
+		for _, a := range n.drain() {
+			seen[a.StorageKey]++
+		}
+
+		for pi := range c.nodes {
+			for j := 0; j < numEvents; j++ {
+				key := "meshtest:" + strconv.Itoa(pi) + ":" + strconv.Itoa(j)
+				if count := seen[key]; count != 1 {
+					msg += fmt.Sprintf("node %d saw %s %d times\n", i, key, count)
+				}
+			}
+		}
+	}
+
+	if msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// kill closes all the clients and kills all the nodes which were started
+func (c *cluster) kill() {
+	for _, n := range c.nodes {
+		if n.cl != nil {
+			n.cl.Close()
+		}
+		if n.cmd != nil && n.cmd.Process != nil {
+			n.cmd.Process.Kill()
+			n.cmd.Wait()
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// meshtest starts up several hyrax nodes on this machine, and checks that every
+// event published on any of the nodes is seen exactly once by a client calling
+// mglobal on every node. Nodes are started as separate processes, since a hyrax
+// server keeps its state globally and can't be run more than once in a single
+// process. It needs a hyrax-server binary, and a redis instance to use for
+// storage unless storage-type is memory.
+//
+// The same checks are run by go test, which builds hyrax-server itself and uses
+// the memory storage backend.
+package main
+
+import (
+	"fmt"
+	"github.com/mediocregopher/flagconfig"
+	"os"
+)
+
+func main() {
+	fc := flagconfig.New("hyrax-meshtest")
+	fc.DisallowConfig()
+	fc.StrParam("server", "hyrax-server binary to run", "hyrax-server")
+	fc.StrParam("storage-type", "storage-type to give each node", "redis")
+	fc.StrParam("storage-info", "storage-info to give each node", "127.0.0.1:6379")
+	fc.IntParam("nodes", "number of nodes to start", 4)
+	fc.IntParam("port", "port the first node listens on, the rest listen on the ports after it", 12379)
+	fc.IntParam("events", "number of events to publish on each node", 10)
+	fc.StrParam("layout", "how the nodes are connected to each other. Can be \"mesh\" (each node is given all the others), \"gossip\" (nodes are in mesh mode but find each other by gossiping) or \"tree\" (the first node is the root, the others push to and pull from it)", layoutMesh)
+
+	if err := fc.Parse(); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	ports := make([]int, fc.GetInt("nodes"))
+	for i := range ports {
+		ports[i] = fc.GetInt("port") + i
+	}
+	storageArgs := []string{
+		"--storage-type=" + fc.GetStr("storage-type"),
+		"--storage-info=" + fc.GetStr("storage-info"),
+	}
+
+	c, err := startCluster(
+		fc.GetStr("server"), storageArgs, ports, fc.GetStr("layout"),
+	)
+	if err != nil {
+		fmt.Println("ERR:", err)
+		os.Exit(1)
+	}
+	err = c.check(fc.GetInt("events"))
+	c.kill()
+	if err != nil {
+		fmt.Print(err)
+		fmt.Println("FAIL")
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
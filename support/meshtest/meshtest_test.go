@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// The hyrax-server binary built by TestMain
+var testServer string
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "meshtest")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	testServer = filepath.Join(dir, "hyrax-server")
+	build := exec.Command(
+		"go", "build", "-o", testServer, "github.com/mediocregopher/hyrax/server",
+	)
+	build.Stdout = os.Stdout
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Println("building hyrax-server:", err)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// freePorts returns n ports which nothing is currently listening on
+func freePorts(t *testing.T, n int) []int {
+	ports := make([]int, n)
+	ls := make([]net.Listener, n)
+	for i := range ls {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		ls[i] = l
+		ports[i] = l.Addr().(*net.TCPAddr).Port
+	}
+	return ports
+}
+
+func testLayout(t *testing.T, layout string) {
+	if testing.Short() {
+		t.Skip("starts up a cluster of nodes")
+	}
+	storageArgs := []string{"--storage-type=memory"}
+	c, err := startCluster(testServer, storageArgs, freePorts(t, 4), layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.kill()
+	if err := c.check(10); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMesh(t *testing.T) {
+	testLayout(t, layoutMesh)
+}
+
+func TestMeshGossip(t *testing.T) {
+	testLayout(t, layoutGossip)
+}
+
+func TestTree(t *testing.T) {
+	testLayout(t, layoutTree)
+}
@@ -7,13 +7,20 @@ import (
 )
 
 // JsonTranslator can encode/decode all messages required by hyrax
-// servers/clients to communicate, and implements to the Translator interface
+// servers/clients to communicate, and implements to the Translator interface.
+// Binary values are sent as objects tagged with BinaryTag, and are turned back
+// into Binary when decoded.
 type JsonTranslator struct{}
 
 func (j *JsonTranslator) ToAction(b []byte) (*Action, error) {
 	a := &Action{}
-	err := json.Unmarshal(b, a)
-	return a, err
+	if err := json.Unmarshal(b, a); err != nil {
+		return a, err
+	}
+	for i := range a.Args {
+		a.Args[i] = UntagBinary(a.Args[i])
+	}
+	return a, nil
 }
 
 func (j *JsonTranslator) FromAction(a *Action) ([]byte, error) {
@@ -22,8 +29,11 @@ func (j *JsonTranslator) FromAction(a *Action) ([]byte, error) {
 
 func (j *JsonTranslator) ToActionReturn(b []byte) (*ActionReturn, error) {
 	ar := &ActionReturn{}
-	err := json.Unmarshal(b, ar)
-	return ar, err
+	if err := json.Unmarshal(b, ar); err != nil {
+		return ar, err
+	}
+	ar.Return = UntagBinary(ar.Return)
+	return ar, nil
 }
 
 func (j *JsonTranslator) FromActionReturn(ar *ActionReturn) ([]byte, error) {
@@ -0,0 +1,48 @@
+package types
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// BinaryTag is the key of the object which Binary values are wrapped in by
+// syntaxes which can't carry raw bytes, like json. The value of the key is the
+// bytes encoded as standard base64.
+const BinaryTag = "$binary"
+
+// Binary is a value made up of arbitrary bytes, which may not be valid utf8
+// (e.g. images or protobuf blobs). It can be used as an arg in an Action, and is
+// used in place of strings in the Return of an ActionReturn for Actions which
+// set Binary. Translators which can carry raw bytes should do so, others should
+// wrap it in an object tagged with BinaryTag.
+type Binary []byte
+
+// MarshalJSON implements the json.Marshaler interface, encoding the Binary as
+// {"$binary":"<base64>"}
+func (b Binary) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		BinaryTag: base64.StdEncoding.EncodeToString(b),
+	})
+}
+
+// UntagBinary returns the given decoded value with any objects tagged with
+// BinaryTag, at any depth, replaced by the Binary they encode. Objects which
+// only look like they're tagged are left alone.
+func UntagBinary(i interface{}) interface{} {
+	switch it := i.(type) {
+	case []interface{}:
+		for j := range it {
+			it[j] = UntagBinary(it[j])
+		}
+	case map[string]interface{}:
+		if enc, ok := it[BinaryTag].(string); ok && len(it) == 1 {
+			if b, err := base64.StdEncoding.DecodeString(enc); err == nil {
+				return Binary(b)
+			}
+		}
+		for k := range it {
+			it[k] = UntagBinary(it[k])
+		}
+	}
+	return i
+}
@@ -15,7 +15,8 @@ type Action struct {
 
 	// Args are extra arguments needed for the command. This will depend on the
 	// datastore used. The items in the args list can be of any type, but I
-	// can't imagine needing anything except strings and numbers.
+	// can't imagine needing anything except strings and numbers. Values which
+	// aren't valid utf8 should be given as Binary.
 	Args []interface{} `json:"args,omitempty"`
 
 	// Id is an optional identifier for who is sending this command.
@@ -25,6 +26,32 @@ type Action struct {
 	// add/change data in the datastore. The secret encompasses the command, the
 	// key, and the id.
 	Secret string `json:"secret,omitempty"`
+
+	// Consistent is an optional flag for commands which don't modify their
+	// key. If set the command is sent to the datastore's primary even when
+	// replicas are configured, so that it sees the result of any writes which
+	// came before it.
+	Consistent bool `json:"consistent,omitempty"`
+
+	// Binary is an optional flag for commands on the datastore. If set every
+	// string in the command's return, other than status replies, is given back
+	// as Binary instead, so that values which aren't valid utf8 make it back to
+	// the client intact. Maps are given back as lists of field/value pairs.
+	Binary bool `json:"binary,omitempty"`
+
+	// Origin is set by hyrax on key change events, and is the id of the node
+	// the event originated on. Clients should not set it.
+	Origin string `json:"origin,omitempty"`
+
+	// EventId is set by hyrax on key change events, and together with Origin
+	// uniquely identifies the event within the cluster. Clients should not set
+	// it.
+	EventId uint64 `json:"eid,omitempty"`
+
+	// ClientId is set by hyrax on key change events, and is the id of the
+	// connection which caused the event on its Origin node. Clients should not
+	// set it.
+	ClientId string `json:"cid,omitempty"`
 }
 
 // ActionReturn is the structure that returns to the client are parsed into.